@@ -26,7 +26,8 @@ const app = new cdk.App();
   new ApiStack(app, `${namespace}-api-stack`, {
     env: env,
     accessControlAllowOrigin: `https://${baseDomainName}`,
-    recaptchaSecretArn: 'arn:aws:secretsmanager:ap-southeast-2:250096756762:secret:recaptcha-secret-arn-LQz25E',
+    captchaSecretArn: 'arn:aws:secretsmanager:ap-southeast-2:250096756762:secret:recaptcha-secret-arn-LQz25E',
+    captchaHostnames: [baseDomainName],
     baseDomainName: baseDomainName,
     fullDomainName: apiDomainName
   });
@@ -61,7 +62,8 @@ const app = new cdk.App();
   new ApiStack(app, `${namespace}-api-stack`, {
     env: env,
     accessControlAllowOrigin: 'https://millhouse.dev',
-    recaptchaSecretArn: 'arn:aws:secretsmanager:ap-southeast-2:535766190525:secret:recaptcha-secret-arn-ZO4Wfp',
+    captchaSecretArn: 'arn:aws:secretsmanager:ap-southeast-2:535766190525:secret:recaptcha-secret-arn-ZO4Wfp',
+    captchaHostnames: [baseDomainName],
     baseDomainName: baseDomainName,
     fullDomainName: apiDomainName
   });
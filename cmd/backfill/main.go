@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+)
+
+// backfill sets the Gsi1 index keys on subscriptions that were written before they
+// were maintained on write.
+//
+//	go run ./cmd/backfill -table <table-name> [-profile <profile> -region <region>]
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	profile := flag.String("profile", "", "the AWS profile to use")
+	region := flag.String("region", "", "the AWS region the table is in")
+	tableName := flag.String("table", "", "the name of the DynamoDB table")
+	flag.Parse()
+
+	store, err := db.NewDynamoDBStore(context.Background(), *profile, *region, *tableName)
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the subscription store: %w", err)})
+		os.Exit(1)
+	}
+
+	result, err := store.BackfillSubscriptionIndex(context.Background())
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to backfill subscription index: %w", err), "count": result.Updated})
+		os.Exit(1)
+	}
+
+	// Subscriptions without a creation time can't be sorted in the index, so they're left
+	// for the operator to fix.
+	for _, subscription := range result.Skipped {
+		log.Info(log.Fields{"message": "skipped subscription without a creation time", "id": subscription.ID, "emailAddress": subscription.EmailAddress})
+	}
+
+	log.Info(log.Fields{"message": "backfilled subscription index", "count": result.Updated, "skipped": len(result.Skipped)})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gofor-little/log"
+	"github.com/gofor-little/xlambda"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/captcha"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/outbox"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+	confirm "github.com/strongishllama/millhouse.dev-cdk/lambdas/api/confirm/handler"
+	ping "github.com/strongishllama/millhouse.dev-cdk/lambdas/api/ping/handler"
+	stats "github.com/strongishllama/millhouse.dev-cdk/lambdas/api/stats/handler"
+	subscribe "github.com/strongishllama/millhouse.dev-cdk/lambdas/api/subscribe/handler"
+	unsubscribe "github.com/strongishllama/millhouse.dev-cdk/lambdas/api/unsubscribe/handler"
+)
+
+// devserver hosts the API lambdas behind the same routes as lib/api-stack.ts so the
+// website can be run against the API locally. Subscriptions are kept in memory, the
+// captcha verification always returns the given score and the emails that would be
+// sent to readers are logged instead.
+//
+//	go run ./cmd/devserver [-addr localhost:8080] [-allow-origin '*'] [-recaptcha-score 0.9] [-website-domain millhouse.dev]
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	addr := flag.String("addr", "localhost:8080", "the address to listen on")
+	allowOrigin := flag.String("allow-origin", "*", "the value of the Access-Control-Allow-Origin header")
+	recaptchaScore := flag.Float64("recaptcha-score", 0.9, "the score returned by the stubbed captcha verification")
+	tokenSecret := flag.String("token-secret", "devserver-token-secret", "the secret used to encrypt confirm and unsubscribe tokens")
+	websiteDomain := flag.String("website-domain", "millhouse.dev", "the domain of the website linked to in emails")
+	flag.Parse()
+
+	if err := xlambda.Initialize(*allowOrigin); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the xlambda package: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := token.Initialize(*tokenSecret); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the token package: %w", err)})
+		os.Exit(1)
+	}
+
+	// Every challenge passes with the configured score. The fake client repeats its
+	// last response, so it answers every request.
+	recaptchaClient := xhttp.NewFakeClient()
+	recaptchaClient.On(http.MethodPost, "https://www.google.com/recaptcha/api/siteverify").
+		RespondJSON(http.StatusOK, &captcha.ResponseData{Score: float32(*recaptchaScore), Success: true})
+	verifier := &captcha.RecaptchaV3Verifier{
+		Secret:     "devserver",
+		HTTPClient: recaptchaClient,
+	}
+
+	memoryStore := db.NewMemoryStore()
+	notification.EmailSender = &logSender{apiDomain: *addr}
+	notification.EmailLedger = memoryStore
+
+	store := &devStore{
+		Store: memoryStore,
+		relay: &outbox.Relay{
+			Store:         memoryStore,
+			FromAddress:   "devserver@localhost",
+			APIDomain:     *addr,
+			WebsiteDomain: *websiteDomain,
+		},
+	}
+	confirm.Store = store
+	stats.Store = store
+	subscribe.Store = store
+	subscribe.Verifier = verifier
+	unsubscribe.Store = store
+
+	r := &router{
+		routes: map[route]proxyHandler{
+			{method: http.MethodGet, path: "/"}:             ping.Handler,
+			{method: http.MethodPut, path: "/subscribe"}:    subscribe.Handler,
+			{method: http.MethodGet, path: "/confirm"}:      confirm.Handler,
+			{method: http.MethodGet, path: "/unsubscribe"}:  unsubscribe.Handler,
+			{method: http.MethodPost, path: "/unsubscribe"}: unsubscribe.Handler,
+			{method: http.MethodGet, path: "/stats"}:        stats.Handler,
+		},
+		accessControlAllowOrigin: *allowOrigin,
+	}
+
+	log.Info(log.Fields{"message": "devserver listening", "addr": *addr})
+	if err := http.ListenAndServe(*addr, r); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to serve: %w", err)})
+		os.Exit(1)
+	}
+}
+
+// devStore stands in for the stream lambda by publishing the outbox entries written
+// with each subscription change straight away.
+type devStore struct {
+	*db.Store
+	relay *outbox.Relay
+}
+
+func (d *devStore) CreateSubscription(ctx context.Context, subscription *db.Subscription, entries ...*db.OutboxEntry) error {
+	if err := d.Store.CreateSubscription(ctx, subscription, entries...); err != nil {
+		return err
+	}
+
+	d.publish(ctx, entries)
+
+	return nil
+}
+
+func (d *devStore) UpdateSubscription(ctx context.Context, subscription *db.Subscription, entries ...*db.OutboxEntry) error {
+	if err := d.Store.UpdateSubscription(ctx, subscription, entries...); err != nil {
+		return err
+	}
+
+	d.publish(ctx, entries)
+
+	return nil
+}
+
+func (d *devStore) DeleteSubscription(ctx context.Context, emailAddress string, id string, entries ...*db.OutboxEntry) error {
+	if err := d.Store.DeleteSubscription(ctx, emailAddress, id, entries...); err != nil {
+		return err
+	}
+
+	d.publish(ctx, entries)
+
+	return nil
+}
+
+// publish publishes the entries. Like the stream lambda, failures are logged and don't
+// fail the request that wrote the entries.
+func (d *devStore) publish(ctx context.Context, entries []*db.OutboxEntry) {
+	for _, entry := range entries {
+		if err := d.relay.Publish(ctx, entry); err != nil {
+			log.Error(log.Fields{"error": err, "outboxEntryId": entry.ID})
+		}
+	}
+}
+
+// logSender logs emails instead of sending them. The devserver doesn't serve HTTPS, so
+// links to it are logged with the http scheme.
+type logSender struct {
+	apiDomain string
+}
+
+func (l *logSender) Send(ctx context.Context, message *notification.Message) (string, error) {
+	log.Info(log.Fields{
+		"message":  "email",
+		"to":       message.To,
+		"subject":  message.Subject,
+		"textBody": strings.ReplaceAll(message.TextBody, "https://"+l.apiDomain, "http://"+l.apiDomain),
+	})
+
+	return "", nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+)
+
+// proxyHandler is the signature of the API lambda handlers.
+type proxyHandler func(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error)
+
+// route is a method and path that API Gateway sends to a lambda handler.
+type route struct {
+	method string
+	path   string
+}
+
+// router sends requests to the lambda handler of their route, the same way API Gateway
+// does with a proxy integration.
+type router struct {
+	routes map[route]proxyHandler
+	// accessControlAllowOrigin is returned on preflight requests in place of API
+	// Gateway's default CORS preflight options.
+	accessControlAllowOrigin string
+}
+
+func (r *router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodOptions {
+		r.preflight(w, req)
+		return
+	}
+
+	handler, ok := r.routes[route{method: req.Method, path: req.URL.Path}]
+	if !ok {
+		status := http.StatusNotFound
+		if r.hasPath(req.URL.Path) {
+			status = http.StatusMethodNotAllowed
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	request, err := toProxyRequest(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to convert request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response, err := handler(req.Context(), request)
+	if err != nil {
+		// Lambda returns a 502 through API Gateway when the handler returns an error.
+		log.Error(log.Fields{"error": err, "method": req.Method, "path": req.URL.Path})
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	if err := writeProxyResponse(w, response); err != nil {
+		log.Error(log.Fields{"error": err, "method": req.Method, "path": req.URL.Path})
+	}
+}
+
+func (r *router) preflight(w http.ResponseWriter, req *http.Request) {
+	if !r.hasPath(req.URL.Path) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", r.accessControlAllowOrigin)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type,X-Amz-Date,Authorization,X-Api-Key,X-Amz-Security-Token,X-Amz-User-Agent")
+	w.Header().Set("Access-Control-Allow-Methods", "OPTIONS,GET,PUT,POST,DELETE,PATCH,HEAD")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *router) hasPath(path string) bool {
+	for route := range r.routes {
+		if route.path == path {
+			return true
+		}
+	}
+
+	return false
+}
+
+// toProxyRequest converts an HTTP request into the event API Gateway sends to a lambda.
+func toProxyRequest(req *http.Request) (*events.APIGatewayProxyRequest, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	request := &events.APIGatewayProxyRequest{
+		Resource:                        req.URL.Path,
+		Path:                            req.URL.Path,
+		HTTPMethod:                      req.Method,
+		Headers:                         map[string]string{},
+		MultiValueHeaders:               map[string][]string{},
+		QueryStringParameters:           map[string]string{},
+		MultiValueQueryStringParameters: map[string][]string{},
+		Body:                            string(body),
+	}
+
+	for name, values := range req.Header {
+		request.Headers[name] = values[len(values)-1]
+		request.MultiValueHeaders[name] = values
+	}
+
+	for name, values := range req.URL.Query() {
+		request.QueryStringParameters[name] = values[len(values)-1]
+		request.MultiValueQueryStringParameters[name] = values
+	}
+
+	return request, nil
+}
+
+// writeProxyResponse writes a lambda's response the way API Gateway would.
+func writeProxyResponse(w http.ResponseWriter, response *events.APIGatewayProxyResponse) error {
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	for name, values := range response.MultiValueHeaders {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	body := []byte(response.Body)
+	if response.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(response.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+	}
+
+	w.WriteHeader(response.StatusCode)
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("failed to write response body: %w", err)
+	}
+
+	return nil
+}
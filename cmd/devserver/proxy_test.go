@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	var received *events.APIGatewayProxyRequest
+	r := &router{
+		routes: map[route]proxyHandler{
+			{method: http.MethodPut, path: "/subscribe"}: func(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+				received = request
+				return &events.APIGatewayProxyResponse{
+					StatusCode: http.StatusCreated,
+					Headers:    map[string]string{"Content-Type": "application/json"},
+					Body:       `{"ok": true}`,
+				}, nil
+			},
+		},
+		accessControlAllowOrigin: "*",
+	}
+
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/subscribe?a=1&a=2", strings.NewReader(`{"emailAddress": "test@example.com"}`)))
+	require.Equal(t, http.StatusCreated, recorder.Code)
+	require.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	require.JSONEq(t, `{"ok": true}`, recorder.Body.String())
+	require.Equal(t, http.MethodPut, received.HTTPMethod)
+	require.Equal(t, "2", received.QueryStringParameters["a"])
+	require.Equal(t, []string{"1", "2"}, received.MultiValueQueryStringParameters["a"])
+	require.JSONEq(t, `{"emailAddress": "test@example.com"}`, received.Body)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/subscribe", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	require.Equal(t, http.StatusNotFound, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, httptest.NewRequest(http.MethodOptions, "/subscribe", nil))
+	require.Equal(t, http.StatusNoContent, recorder.Code)
+	require.Equal(t, "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/gofor-little/log"
+	"github.com/gofor-little/xrand"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+)
+
+// review lists the subscriptions held because of a borderline captcha score, or
+// approves or rejects one of them. Approved subscriptions are sent a confirmation email
+// and rejected ones are deleted.
+//
+//	go run ./cmd/review -table <table-name> [-approve <email> | -reject <email>] [-profile <profile> -region <region>]
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	profile := flag.String("profile", "", "the AWS profile to use")
+	region := flag.String("region", "", "the AWS region the table is in")
+	tableName := flag.String("table", "", "the name of the DynamoDB table")
+	approve := flag.String("approve", "", "the email address of the held subscription to approve")
+	reject := flag.String("reject", "", "the email address of the held subscription to reject")
+	flag.Parse()
+
+	if *approve != "" && *reject != "" {
+		log.Error(log.Fields{"error": "only one of -approve and -reject can be set"})
+		os.Exit(1)
+	}
+
+	store, err := db.NewDynamoDBStore(context.Background(), *profile, *region, *tableName)
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the subscription store: %w", err)})
+		os.Exit(1)
+	}
+
+	switch {
+	case *approve != "":
+		err = approveSubscription(context.Background(), store, *approve)
+	case *reject != "":
+		err = rejectSubscription(context.Background(), store, *reject)
+	default:
+		err = listSubscriptions(context.Background(), store)
+	}
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+}
+
+func listSubscriptions(ctx context.Context, store *db.Store) error {
+	subscriptions, err := store.GetSubscriptions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get subscriptions: %w", err)
+	}
+
+	count := 0
+	for _, subscription := range subscriptions {
+		if subscription.Status != db.SubscriptionStatusHeld || subscription.IsExpired() {
+			continue
+		}
+
+		log.Info(log.Fields{"emailAddress": subscription.EmailAddress, "createdAt": subscription.CreatedAt})
+		count++
+	}
+
+	log.Info(log.Fields{"message": "listed held subscriptions", "count": count})
+
+	return nil
+}
+
+func approveSubscription(ctx context.Context, store *db.Store, emailAddress string) error {
+	subscription, err := getHeldSubscription(ctx, store, emailAddress)
+	if err != nil {
+		return err
+	}
+
+	id, err := xrand.UUIDV4()
+	if err != nil {
+		return fmt.Errorf("failed to generate UUID: %w", err)
+	}
+
+	// The confirmation email is sent from the outbox so the reader still has to confirm
+	// the subscription.
+	subscription.Approve()
+	if err := store.UpdateSubscription(ctx, subscription, db.NewOutboxEntry(id, db.OutboxKindSubscriptionConfirmation, subscription)); err != nil {
+		return fmt.Errorf("failed to approve subscription: %w", err)
+	}
+
+	log.Info(log.Fields{"message": "approved subscription", "subscriptionId": subscription.ID})
+
+	return nil
+}
+
+func rejectSubscription(ctx context.Context, store *db.Store, emailAddress string) error {
+	subscription, err := getHeldSubscription(ctx, store, emailAddress)
+	if err != nil {
+		return err
+	}
+
+	if err := store.DeleteSubscription(ctx, subscription.EmailAddress, subscription.ID); err != nil {
+		return fmt.Errorf("failed to reject subscription: %w", err)
+	}
+
+	log.Info(log.Fields{"message": "rejected subscription", "subscriptionId": subscription.ID})
+
+	return nil
+}
+
+func getHeldSubscription(ctx context.Context, store *db.Store, emailAddress string) (*db.Subscription, error) {
+	subscription, err := store.GetSubscription(ctx, emailAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if subscription == nil || subscription.IsExpired() {
+		return nil, fmt.Errorf("no subscription found for %s", emailAddress)
+	}
+	if subscription.Status != db.SubscriptionStatusHeld {
+		return nil, fmt.Errorf("subscription for %s is %s, not held", emailAddress, subscription.Status)
+	}
+
+	return subscription, nil
+}
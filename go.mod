@@ -9,7 +9,9 @@ require (
 	github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue v1.9.2
 	github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression v1.4.8
 	github.com/aws/aws-sdk-go-v2/service/dynamodb v1.15.5
+	github.com/aws/aws-sdk-go-v2/service/ses v1.5.2
 	github.com/aws/aws-sdk-go-v2/service/sqs v1.18.5
+	github.com/go-mail/mail v2.3.1+incompatible
 	github.com/gofor-little/aws-email v0.2.1
 	github.com/gofor-little/cfg v0.3.1
 	github.com/gofor-little/env v1.0.3
@@ -0,0 +1,154 @@
+package captcha
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofor-little/env"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+// Verifier verifies the response token a captcha widget gave a reader once they
+// completed its challenge.
+type Verifier interface {
+	// Verify checks the response token with the provider and against the verifier's
+	// options. An error is only returned if the token couldn't be checked, a rejected
+	// token is an unsuccessful result.
+	Verify(ctx context.Context, responseToken string) (*Result, error)
+}
+
+// Reasons a challenge failed the verifier's options. Other reasons are the provider's
+// error codes, such as "invalid-input-response".
+const (
+	ReasonHostnameMismatch = "hostname-mismatch"
+	ReasonActionMismatch   = "action-mismatch"
+	ReasonTokenTooOld      = "token-too-old"
+)
+
+// Result is the outcome of a verification.
+type Result struct {
+	Success bool
+	// Reasons explains why an unsuccessful challenge failed. It holds the provider's
+	// error codes and the Reason constants of the options that weren't met.
+	Reasons []string
+	// Score is how likely the challenge was completed by a human, from 0 to 1. Providers
+	// that don't score challenges return 1 and unsuccessful challenges are scored 0.
+	Score float32
+	// Hostname is the site the challenge was completed on.
+	Hostname string
+	// Action is the name of the action the challenge was completed for, if the provider
+	// supports actions.
+	Action string
+	// ChallengeTS is when the challenge was completed.
+	ChallengeTS time.Time
+}
+
+// Options are the extra checks a verifier makes once the provider accepts a token.
+// The zero value makes no extra checks.
+type Options struct {
+	// Hostnames are the sites challenges may be completed on. Any site is allowed if
+	// it's empty.
+	Hostnames []string
+	// Action is the expected action name. Only reCAPTCHA v3 and Turnstile return the
+	// action so the other providers ignore it. Any action is allowed if it's empty.
+	Action string
+	// MaxTokenAge is how long after a challenge is completed its token is accepted.
+	// Tokens of any age the provider accepts are allowed if it's zero.
+	MaxTokenAge time.Duration
+}
+
+// Provider is a captcha provider.
+type Provider string
+
+const (
+	ProviderRecaptchaV2 Provider = "recaptcha-v2"
+	ProviderRecaptchaV3 Provider = "recaptcha-v3"
+	ProviderHCaptcha    Provider = "hcaptcha"
+	ProviderTurnstile   Provider = "turnstile"
+)
+
+// Config selects the Verifier returned by NewVerifier.
+type Config struct {
+	Provider Provider
+	// Secret is the provider's secret key for the site.
+	Secret string
+	// HTTPClient sends the verification requests. A client that retries requests that
+	// couldn't be sent and times out attempts after 3 seconds is used if it's nil.
+	HTTPClient xhttp.Client
+	Options    Options
+	// Policy decides what to do with verified requests. It isn't used by the verifier
+	// but is configured alongside it.
+	Policy Policy
+}
+
+// ConfigFromEnv returns the config of the provider set in the CAPTCHA_PROVIDER
+// environment variable, defaulting to reCAPTCHA v3. The options are read from
+// CAPTCHA_HOSTNAMES, a comma separated list, CAPTCHA_ACTION and CAPTCHA_MAX_TOKEN_AGE,
+// a duration such as "2m". The policy's scores are read from CAPTCHA_ACCEPT_SCORE and
+// CAPTCHA_HOLD_SCORE, defaulting to DefaultPolicy. The secret is usually kept in a
+// secret store so it isn't read from the environment and must be set by the caller.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Provider: Provider(env.Get("CAPTCHA_PROVIDER", string(ProviderRecaptchaV3))),
+		Options: Options{
+			Action: env.Get("CAPTCHA_ACTION", ""),
+		},
+		Policy: DefaultPolicy,
+	}
+
+	for _, hostname := range strings.Split(env.Get("CAPTCHA_HOSTNAMES", ""), ",") {
+		if hostname = strings.TrimSpace(hostname); hostname != "" {
+			config.Options.Hostnames = append(config.Options.Hostnames, hostname)
+		}
+	}
+
+	if maxTokenAge := env.Get("CAPTCHA_MAX_TOKEN_AGE", ""); maxTokenAge != "" {
+		var err error
+		config.Options.MaxTokenAge, err = time.ParseDuration(maxTokenAge)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to parse CAPTCHA_MAX_TOKEN_AGE: %w", err)
+		}
+	}
+
+	for name, score := range map[string]*float32{
+		"CAPTCHA_ACCEPT_SCORE": &config.Policy.AcceptScore,
+		"CAPTCHA_HOLD_SCORE":   &config.Policy.HoldScore,
+	} {
+		value := env.Get(name, "")
+		if value == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(value, 32)
+		if err != nil {
+			return Config{}, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		*score = float32(parsed)
+	}
+
+	if err := config.Policy.Validate(); err != nil {
+		return Config{}, fmt.Errorf("failed to validate captcha policy: %w", err)
+	}
+
+	return config, nil
+}
+
+// NewVerifier returns the Verifier for the config's provider.
+func NewVerifier(config Config) (Verifier, error) {
+	switch config.Provider {
+	case ProviderRecaptchaV2:
+		return &RecaptchaV2Verifier{Secret: config.Secret, HTTPClient: config.HTTPClient, Options: config.Options}, nil
+	case ProviderRecaptchaV3:
+		return &RecaptchaV3Verifier{Secret: config.Secret, HTTPClient: config.HTTPClient, Options: config.Options}, nil
+	case ProviderHCaptcha:
+		return &HCaptchaVerifier{Secret: config.Secret, HTTPClient: config.HTTPClient, Options: config.Options}, nil
+	case ProviderTurnstile:
+		return &TurnstileVerifier{Secret: config.Secret, HTTPClient: config.HTTPClient, Options: config.Options}, nil
+	default:
+		return nil, fmt.Errorf("unknown captcha provider: %q", config.Provider)
+	}
+}
@@ -0,0 +1,195 @@
+package captcha_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/captcha"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+func TestVerifier(t *testing.T) {
+	testCases := []struct {
+		provider captcha.Provider
+		url      string
+		score    float32
+	}{
+		{provider: captcha.ProviderRecaptchaV2, url: "https://www.google.com/recaptcha/api/siteverify", score: 1},
+		{provider: captcha.ProviderRecaptchaV3, url: "https://www.google.com/recaptcha/api/siteverify", score: 0.7},
+		{provider: captcha.ProviderHCaptcha, url: "https://api.hcaptcha.com/siteverify", score: 1},
+		{provider: captcha.ProviderTurnstile, url: "https://challenges.cloudflare.com/turnstile/v0/siteverify", score: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.provider), func(t *testing.T) {
+			client := xhttp.NewFakeClient()
+			client.On(http.MethodPost, tc.url).
+				RespondJSON(http.StatusOK, &captcha.ResponseData{Score: 0.7, Success: true, Hostname: "example.com"})
+			verifier, err := captcha.NewVerifier(captcha.Config{
+				Provider:   tc.provider,
+				Secret:     "secret",
+				HTTPClient: client,
+				Options: captcha.Options{
+					Hostnames: []string{"Example.com"},
+				},
+			})
+			require.NoError(t, err)
+
+			result, err := verifier.Verify(context.Background(), "token")
+			require.NoError(t, err)
+			require.InDelta(t, tc.score, result.Score, 0.001)
+			require.Equal(t, "example.com", result.Hostname)
+		})
+	}
+
+	client := xhttp.NewFakeClient()
+	client.On(http.MethodPost, "https://challenges.cloudflare.com/turnstile/v0/siteverify").
+		RespondJSON(http.StatusOK, &captcha.ResponseData{ErrorCodes: []string{"invalid-input-response"}})
+	verifier, err := captcha.NewVerifier(captcha.Config{
+		Provider:   captcha.ProviderTurnstile,
+		HTTPClient: client,
+	})
+	require.NoError(t, err)
+	result, err := verifier.Verify(context.Background(), "token")
+	require.NoError(t, err)
+	require.False(t, result.Success)
+	require.Equal(t, []string{"invalid-input-response"}, result.Reasons)
+
+	_, err = captcha.NewVerifier(captcha.Config{Provider: "unknown"})
+	require.Error(t, err)
+}
+
+// recaptchaTestSecret is the secret of reCAPTCHA's test keys, whose challenges always
+// pass. It's public, although it's still redacted from the cassette.
+const recaptchaTestSecret = "6LeIxAcTAAAAAGG-vFI1TnRWxMZNFuojJ4WifJWe"
+
+func TestVerifierReplay(t *testing.T) {
+	// Set RECORD_CASSETTES to re-record the cassette from reCAPTCHA's siteverify endpoint.
+	mode := xhttp.RecorderModeReplay
+	if os.Getenv("RECORD_CASSETTES") != "" {
+		mode = xhttp.RecorderModeRecord
+	}
+	recorder, err := xhttp.NewRecorder(mode, "testdata/siteverify.json", &http.Client{}, "secret")
+	require.NoError(t, err)
+	if mode == xhttp.RecorderModeRecord {
+		t.Cleanup(func() {
+			if !t.Failed() {
+				require.NoError(t, recorder.Save())
+			}
+		})
+	}
+
+	verifier := &captcha.RecaptchaV2Verifier{
+		Secret:     recaptchaTestSecret,
+		HTTPClient: recorder,
+		Options:    captcha.Options{Hostnames: []string{"testkey.google.com"}},
+	}
+	result, err := verifier.Verify(context.Background(), "valid-token")
+	require.NoError(t, err)
+	require.True(t, result.Success)
+	require.Equal(t, "testkey.google.com", result.Hostname)
+
+	verifier.Secret = "invalid-secret"
+	result, err = verifier.Verify(context.Background(), "invalid-secret-token")
+	require.NoError(t, err)
+	require.False(t, result.Success)
+	require.Contains(t, result.Reasons, "invalid-input-secret")
+}
+
+func TestVerifierErrors(t *testing.T) {
+	client := xhttp.NewFakeClient()
+	client.On(http.MethodPost, "https://api.hcaptcha.com/siteverify").
+		Respond(http.StatusInternalServerError, "internal error").
+		Respond(http.StatusOK, "<html>not json</html>").
+		RespondError(errors.New("connection reset"))
+	verifier := &captcha.HCaptchaVerifier{Secret: "secret", HTTPClient: client}
+
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(context.Background(), "token")
+		require.Error(t, err)
+	}
+
+	calls := client.Calls()
+	require.Len(t, calls, 3)
+	form, err := calls[0].Form()
+	require.NoError(t, err)
+	require.Equal(t, "secret", form.Get("secret"))
+	require.Equal(t, "token", form.Get("response"))
+}
+
+func TestVerifierOptions(t *testing.T) {
+	options := captcha.Options{
+		Hostnames:   []string{"example.com"},
+		Action:      "subscribe",
+		MaxTokenAge: 2 * time.Minute,
+	}
+
+	testCases := []struct {
+		name         string
+		responseData *captcha.ResponseData
+		reasons      []string
+	}{
+		{
+			name:         "valid",
+			responseData: &captcha.ResponseData{Success: true, Score: 0.9, Hostname: "example.com", Action: "subscribe", ChallengeTS: time.Now()},
+			reasons:      []string{},
+		},
+		{
+			name:         "invalid",
+			responseData: &captcha.ResponseData{Success: true, Score: 0.9, Hostname: "attacker.example", Action: "login", ChallengeTS: time.Now().Add(-time.Hour)},
+			reasons:      []string{captcha.ReasonHostnameMismatch, captcha.ReasonActionMismatch, captcha.ReasonTokenTooOld},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := xhttp.NewFakeClient()
+			client.On(http.MethodPost, "https://www.google.com/recaptcha/api/siteverify").RespondJSON(http.StatusOK, tc.responseData)
+			verifier := &captcha.RecaptchaV3Verifier{
+				HTTPClient: client,
+				Options:    options,
+			}
+
+			result, err := verifier.Verify(context.Background(), "token")
+			require.NoError(t, err)
+			require.Equal(t, len(tc.reasons) == 0, result.Success)
+			require.Equal(t, tc.reasons, result.Reasons)
+		})
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy := captcha.Policy{AcceptScore: 0.7, HoldScore: 0.3}
+	require.NoError(t, policy.Validate())
+	require.Error(t, captcha.Policy{AcceptScore: 0.3, HoldScore: 0.7}.Validate())
+
+	testCases := []struct {
+		name     string
+		result   *captcha.Result
+		decision captcha.Decision
+	}{
+		{name: "accept", result: &captcha.Result{Success: true, Score: 0.8}, decision: captcha.DecisionAccept},
+		{name: "accept score", result: &captcha.Result{Success: true, Score: 0.7}, decision: captcha.DecisionHold},
+		{name: "hold", result: &captcha.Result{Success: true, Score: 0.5}, decision: captcha.DecisionHold},
+		{name: "reject", result: &captcha.Result{Success: true, Score: 0.1}, decision: captcha.DecisionReject},
+		{name: "unsuccessful", result: &captcha.Result{Success: false, Score: 0.9}, decision: captcha.DecisionReject},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.decision, policy.Decide(tc.result))
+		})
+	}
+
+	// A score of exactly 0.5 isn't accepted by the default policy, and is rejected when
+	// holding is disabled.
+	result := &captcha.Result{Success: true, Score: 0.5}
+	require.Equal(t, captcha.DecisionHold, captcha.DefaultPolicy.Decide(result))
+	require.Equal(t, captcha.DecisionReject, captcha.Policy{AcceptScore: 0.5, HoldScore: 0.5}.Decide(result))
+}
@@ -0,0 +1,60 @@
+package captcha
+
+import (
+	"fmt"
+)
+
+// Decision is what to do with a request once its challenge has been verified.
+type Decision string
+
+const (
+	// DecisionAccept lets the request through.
+	DecisionAccept Decision = "ACCEPT"
+	// DecisionHold keeps the request for the site owner to review.
+	DecisionHold Decision = "HOLD"
+	// DecisionReject drops the request.
+	DecisionReject Decision = "REJECT"
+)
+
+// Policy splits scores into accept, hold and reject bands.
+type Policy struct {
+	// AcceptScore is the score that must be exceeded to be accepted.
+	AcceptScore float32
+	// HoldScore is the lowest score that is held for review. Scores below it are
+	// rejected. Setting it to AcceptScore disables holding.
+	HoldScore float32
+}
+
+// DefaultPolicy accepts scores above reCAPTCHA v3's recommended threshold of 0.5, holds
+// borderline scores and rejects the rest.
+var DefaultPolicy = Policy{
+	AcceptScore: 0.5,
+	HoldScore:   0.3,
+}
+
+// Decide returns the decision for a verification. Unsuccessful challenges are always
+// rejected.
+func (p Policy) Decide(result *Result) Decision {
+	switch {
+	case !result.Success:
+		return DecisionReject
+	case result.Score > p.AcceptScore:
+		return DecisionAccept
+	case result.Score >= p.HoldScore && p.HoldScore < p.AcceptScore:
+		return DecisionHold
+	default:
+		return DecisionReject
+	}
+}
+
+// Validate checks the bands are in order and within the range of scores.
+func (p Policy) Validate() error {
+	if p.HoldScore < 0 || p.AcceptScore > 1 {
+		return fmt.Errorf("scores must be between 0 and 1: hold %.2f, accept %.2f", p.HoldScore, p.AcceptScore)
+	}
+	if p.HoldScore > p.AcceptScore {
+		return fmt.Errorf("hold score %.2f cannot be greater than accept score %.2f", p.HoldScore, p.AcceptScore)
+	}
+
+	return nil
+}
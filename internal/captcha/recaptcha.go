@@ -0,0 +1,42 @@
+package captcha
+
+import (
+	"context"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+const recaptchaURL = "https://www.google.com/recaptcha/api/siteverify"
+
+// RecaptchaV2Verifier verifies reCAPTCHA v2 checkbox and invisible challenges. They
+// aren't scored so successful verifications have a score of 1.
+type RecaptchaV2Verifier struct {
+	Secret     string
+	HTTPClient xhttp.Client
+	Options    Options
+}
+
+func (r *RecaptchaV2Verifier) Verify(ctx context.Context, responseToken string) (*Result, error) {
+	responseData, err := siteverify(ctx, r.HTTPClient, recaptchaURL, r.Secret, responseToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResult(responseData, 1, r.Options.check(responseData, false)), nil
+}
+
+// RecaptchaV3Verifier verifies reCAPTCHA v3 challenges, which are scored from 0 to 1.
+type RecaptchaV3Verifier struct {
+	Secret     string
+	HTTPClient xhttp.Client
+	Options    Options
+}
+
+func (r *RecaptchaV3Verifier) Verify(ctx context.Context, responseToken string) (*Result, error) {
+	responseData, err := siteverify(ctx, r.HTTPClient, recaptchaURL, r.Secret, responseToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResult(responseData, responseData.Score, r.Options.check(responseData, true)), nil
+}
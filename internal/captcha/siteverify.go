@@ -0,0 +1,123 @@
+package captcha
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+// ResponseData is the body returned by a siteverify endpoint. Every provider returns
+// the same fields, although only reCAPTCHA v3 scores challenges and only reCAPTCHA v3
+// and Turnstile return the action.
+type ResponseData struct {
+	Action      string    `json:"action"`
+	ChallengeTS time.Time `json:"challenge_ts"`
+	Hostname    string    `json:"hostname"`
+	Score       float32   `json:"score"`
+	Success     bool      `json:"success"`
+	ErrorCodes  []string  `json:"error-codes"`
+}
+
+// defaultClient is used by verifiers without an HTTP client. Each attempt has up to 3
+// seconds, leaving a second before the deadline for the handler to respond. Its circuit
+// breaker is shared by every provider as only one is used at a time.
+var defaultClient = xhttp.NewResilientClient(&http.Client{}, siteverifyRetryOptions, xhttp.NewCircuitBreaker(5, 30*time.Second), 3*time.Second, time.Second)
+
+// siteverifyRetryOptions only retries requests that weren't sent. Response tokens can
+// only be verified once, so retrying a request the provider may have received gets a
+// timeout-or-duplicate error and rejects the reader.
+var siteverifyRetryOptions = xhttp.RetryOptions{
+	MaxAttempts: xhttp.DefaultRetryOptions.MaxAttempts,
+	BaseDelay:   xhttp.DefaultRetryOptions.BaseDelay,
+	MaxDelay:    xhttp.DefaultRetryOptions.MaxDelay,
+	UnsentOnly:  true,
+}
+
+// siteverify posts the response token to a provider's siteverify endpoint and returns
+// its response.
+func siteverify(ctx context.Context, client xhttp.Client, endpoint string, secret string, responseToken string) (*ResponseData, error) {
+	form := url.Values{}
+	form.Set("secret", secret)
+	form.Set("response", responseToken)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build HTTP request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if client == nil {
+		client = defaultClient
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code returned: %d", response.StatusCode)
+	}
+
+	responseData := &ResponseData{}
+	if err := json.NewDecoder(response.Body).Decode(&responseData); err != nil {
+		return nil, fmt.Errorf("failed to decode response body: %w", err)
+	}
+
+	return responseData, nil
+}
+
+// newResult returns the result of a response. The challenge only succeeds if the
+// provider accepted it and there are no reasons from the options' checks.
+func newResult(responseData *ResponseData, score float32, reasons []string) *Result {
+	result := &Result{
+		Success:     responseData.Success && len(reasons) == 0,
+		Reasons:     append(append([]string{}, responseData.ErrorCodes...), reasons...),
+		Score:       score,
+		Hostname:    responseData.Hostname,
+		Action:      responseData.Action,
+		ChallengeTS: responseData.ChallengeTS,
+	}
+	if !result.Success {
+		result.Score = 0
+	}
+
+	return result
+}
+
+// check returns the reasons the response doesn't meet the options. The action is
+// only checked if checkAction is true, for the providers that return it.
+func (o Options) check(responseData *ResponseData, checkAction bool) []string {
+	reasons := []string{}
+
+	if len(o.Hostnames) > 0 {
+		allowed := false
+		for _, hostname := range o.Hostnames {
+			if strings.EqualFold(hostname, responseData.Hostname) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			reasons = append(reasons, ReasonHostnameMismatch)
+		}
+	}
+
+	if checkAction && o.Action != "" && o.Action != responseData.Action {
+		reasons = append(reasons, ReasonActionMismatch)
+	}
+
+	// Tokens without a challenge timestamp can't be shown to be new enough.
+	if o.MaxTokenAge > 0 && (responseData.ChallengeTS.IsZero() || time.Since(responseData.ChallengeTS) > o.MaxTokenAge) {
+		reasons = append(reasons, ReasonTokenTooOld)
+	}
+
+	return reasons
+}
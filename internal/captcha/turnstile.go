@@ -0,0 +1,26 @@
+package captcha
+
+import (
+	"context"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+const turnstileURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+// TurnstileVerifier verifies Cloudflare Turnstile challenges. They aren't scored so
+// successful verifications have a score of 1.
+type TurnstileVerifier struct {
+	Secret     string
+	HTTPClient xhttp.Client
+	Options    Options
+}
+
+func (t *TurnstileVerifier) Verify(ctx context.Context, responseToken string) (*Result, error) {
+	responseData, err := siteverify(ctx, t.HTTPClient, turnstileURL, t.Secret, responseToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResult(responseData, 1, t.Options.check(responseData, true)), nil
+}
@@ -0,0 +1,165 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// AlertKind is the event an alert reports to the site owner.
+type AlertKind string
+
+const (
+	// AlertKindReaderUnsubscribed is raised when a confirmed reader unsubscribes.
+	AlertKindReaderUnsubscribed AlertKind = "READER_UNSUBSCRIBED"
+	// AlertKindRecaptchaChallengeFailed is raised when a subscribe request is rejected
+	// because of a low recaptcha score.
+	AlertKindRecaptchaChallengeFailed AlertKind = "RECAPTCHA_CHALLENGE_FAILED"
+	// AlertKindSubscriptionHeld is raised when a subscription is held for review because
+	// of a borderline recaptcha score.
+	AlertKindSubscriptionHeld AlertKind = "SUBSCRIPTION_HELD"
+)
+
+// AlertTTL is how long an alert is kept if it isn't sent in a digest, such as when no
+// owner address is configured.
+const AlertTTL = 7 * 24 * time.Hour
+
+// AlertWindow is the period within which repeated alerts of the same kind about the
+// same email address are only recorded once.
+const AlertWindow = 24 * time.Hour
+
+// Alert is an event the site owner is notified of. Alerts are kept until they're sent
+// in the owner's daily digest or they expire after AlertTTL.
+type Alert struct {
+	ID           string    `json:"id" dynamodbav:"id"`
+	Kind         AlertKind `json:"kind" dynamodbav:"kind"`
+	EmailAddress string    `json:"emailAddress" dynamodbav:"emailAddress"`
+	// Score is the recaptcha score of an AlertKindRecaptchaChallengeFailed or
+	// AlertKindSubscriptionHeld alert.
+	Score     float32   `json:"score,omitempty" dynamodbav:"score,omitempty"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is the Unix time in seconds at which DynamoDB will delete the alert.
+	ExpiresAt int64 `json:"expiresAt" dynamodbav:"expiresAt"`
+}
+
+// NewAlert returns an alert of the given kind about the email address that expires
+// after AlertTTL. Its ID is derived from the kind, the email address and the current
+// AlertWindow so repeated alerts within the window have the same ID.
+func NewAlert(kind AlertKind, emailAddress string) *Alert {
+	now := time.Now().UTC()
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s#%s#%d", kind, emailAddress, now.Truncate(AlertWindow).Unix())))
+
+	return &Alert{
+		ID:           hex.EncodeToString(hash[:]),
+		Kind:         kind,
+		EmailAddress: emailAddress,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(AlertTTL).Unix(),
+	}
+}
+
+// CreateAlert records an alert for the next digest. If an alert with the same ID
+// hasn't been sent yet, the alert is a repeat and isn't recorded again.
+func (s *Store) CreateAlert(ctx context.Context, alert *Alert) error {
+	err := s.table.putItem(ctx, alert, putIfNotExists)
+	if err != nil && !errors.Is(err, ErrAlreadyExists) {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+
+	return nil
+}
+
+// GetAlerts fetches every alert that hasn't been deleted, oldest first.
+func (s *Store) GetAlerts(ctx context.Context) ([]*Alert, error) {
+	alerts := []*Alert{}
+	options := PageOptions{}
+
+	for {
+		page := []*Alert{}
+		cursor, err := s.table.getItems(ctx, ItemTypeAlert, options, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get alerts: %w", err)
+		}
+		alerts = append(alerts, page...)
+
+		if cursor == "" {
+			return alerts, nil
+		}
+		options.Cursor = cursor
+	}
+}
+
+// DeleteAlert deletes an alert once it has been sent. If the alert doesn't exist
+// ErrNotFound is returned.
+func (s *Store) DeleteAlert(ctx context.Context, alert *Alert) error {
+	if err := s.table.deleteItem(ctx, alert); err != nil {
+		return fmt.Errorf("failed to delete alert: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireAlert decrements the count item of an alert that DynamoDB deleted when it
+// expired.
+func (s *Store) ExpireAlert(ctx context.Context, alert *Alert) error {
+	if err := s.table.adjustCounts(ctx, countDeltas(alert, nil)); err != nil {
+		return fmt.Errorf("failed to decrement expired alert count: %w", err)
+	}
+
+	return nil
+}
+
+func (a *Alert) pk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeAlert, a.ID)
+}
+
+func (a *Alert) sk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeAlert, a.ID)
+}
+
+func (a *Alert) countPK() string {
+	return string(ItemTypeCount)
+}
+
+func (a *Alert) countSK() string {
+	return fmt.Sprintf("%s#%s", ItemTypeCount, a.itemType())
+}
+
+func (a *Alert) gsiPK1() string {
+	return string(ItemTypeAlert)
+}
+
+// gsiSK1 sorts alerts by creation time. The ID is appended so alerts created at the
+// same time have unique sort keys.
+func (a *Alert) gsiSK1() string {
+	return fmt.Sprintf("%s#%s", a.CreatedAt.UTC().Format(sortableTimeFormat), a.ID)
+}
+
+func (a *Alert) itemType() ItemType {
+	return ItemTypeAlert
+}
+
+// update only sets the kind and expiry as alerts aren't changed once created.
+func (a *Alert) update() expression.UpdateBuilder {
+	return expression.Set(expression.Name("kind"), expression.Value(a.Kind)).
+		Set(expression.Name("expiresAt"), expression.Value(a.ExpiresAt))
+}
+
+func (a *Alert) validate() error {
+	if len(a.ID) == 0 {
+		return errors.New("id cannot be empty")
+	}
+
+	switch a.Kind {
+	case AlertKindReaderUnsubscribed, AlertKindRecaptchaChallengeFailed, AlertKindSubscriptionHeld:
+	default:
+		return fmt.Errorf("invalid kind: %q", a.Kind)
+	}
+
+	return nil
+}
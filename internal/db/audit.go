@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// AuditAction is the change to a subscription an audit entry records.
+type AuditAction string
+
+const (
+	// AuditActionCreated is recorded when a subscription is created.
+	AuditActionCreated AuditAction = "CREATED"
+	// AuditActionStatusChanged is recorded when a subscription's status changes, such as
+	// when it's confirmed or approved.
+	AuditActionStatusChanged AuditAction = "STATUS_CHANGED"
+	// AuditActionDeleted is recorded when a subscription is deleted, such as when the
+	// reader unsubscribes.
+	AuditActionDeleted AuditAction = "DELETED"
+	// AuditActionExpired is recorded when DynamoDB deletes a subscription that expired.
+	AuditActionExpired AuditAction = "EXPIRED"
+)
+
+// AuditEntry records a change to a subscription. Entries are written by the stream
+// lambda from the table's stream and are kept as the history of each subscription.
+type AuditEntry struct {
+	// ID is the ID of the stream record the entry was written for, so a retried record
+	// doesn't write a second entry.
+	ID             string      `json:"id" dynamodbav:"id"`
+	Action         AuditAction `json:"action" dynamodbav:"action"`
+	SubscriptionID string      `json:"subscriptionId" dynamodbav:"subscriptionId"`
+	EmailAddress   string      `json:"emailAddress" dynamodbav:"emailAddress"`
+	// Status is the subscription's status after the change, or when it was removed.
+	Status SubscriptionStatus `json:"status" dynamodbav:"status"`
+	// PreviousStatus is the subscription's status before an AuditActionStatusChanged.
+	PreviousStatus SubscriptionStatus `json:"previousStatus,omitempty" dynamodbav:"previousStatus,omitempty"`
+	CreatedAt      time.Time          `json:"createdAt" dynamodbav:"createdAt"`
+}
+
+// NewAuditEntry returns an audit entry of the action on the subscription.
+func NewAuditEntry(id string, action AuditAction, subscription *Subscription) *AuditEntry {
+	return &AuditEntry{
+		ID:             id,
+		Action:         action,
+		SubscriptionID: subscription.ID,
+		EmailAddress:   subscription.EmailAddress,
+		Status:         subscription.Status,
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+// CreateAuditEntry records an audit entry. If an entry with the same ID has already
+// been recorded it isn't recorded again.
+func (s *Store) CreateAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	err := s.table.putItem(ctx, entry, putIfNotExists)
+	if err != nil && !errors.Is(err, ErrAlreadyExists) {
+		return fmt.Errorf("failed to create audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// GetAuditEntries fetches every audit entry, oldest first.
+func (s *Store) GetAuditEntries(ctx context.Context) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	options := PageOptions{}
+
+	for {
+		page := []*AuditEntry{}
+		cursor, err := s.table.getItems(ctx, ItemTypeAudit, options, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get audit entries: %w", err)
+		}
+		entries = append(entries, page...)
+
+		if cursor == "" {
+			return entries, nil
+		}
+		options.Cursor = cursor
+	}
+}
+
+// pk groups a subscription's audit entries in one partition.
+func (e *AuditEntry) pk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeAudit, e.SubscriptionID)
+}
+
+func (e *AuditEntry) sk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeAudit, e.ID)
+}
+
+func (e *AuditEntry) countPK() string {
+	return string(ItemTypeCount)
+}
+
+func (e *AuditEntry) countSK() string {
+	return fmt.Sprintf("%s#%s", ItemTypeCount, e.itemType())
+}
+
+func (e *AuditEntry) gsiPK1() string {
+	return string(ItemTypeAudit)
+}
+
+func (e *AuditEntry) gsiSK1() string {
+	return fmt.Sprintf("%s#%s", e.CreatedAt.UTC().Format(sortableTimeFormat), e.ID)
+}
+
+func (e *AuditEntry) itemType() ItemType {
+	return ItemTypeAudit
+}
+
+// update only sets the action as audit entries aren't changed once created.
+func (e *AuditEntry) update() expression.UpdateBuilder {
+	return expression.Set(expression.Name("action"), expression.Value(e.Action))
+}
+
+func (e *AuditEntry) validate() error {
+	if len(e.ID) == 0 {
+		return errors.New("id cannot be empty")
+	}
+
+	if len(e.SubscriptionID) == 0 {
+		return errors.New("subscription id cannot be empty")
+	}
+
+	switch e.Action {
+	case AuditActionCreated, AuditActionStatusChanged, AuditActionDeleted, AuditActionExpired:
+	default:
+		return fmt.Errorf("invalid action: %q", e.Action)
+	}
+
+	return nil
+}
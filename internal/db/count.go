@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// countItem is the secondary item that keeps a count of the items of a type.
+type countItem struct {
+	Count int64 `dynamodbav:"count"`
+}
+
+// GetCount fetches the number of items of the given type from its count item.
+func (s *Store) GetCount(ctx context.Context, it ItemType) (int64, error) {
+	count, err := s.getCount(ctx, countKey{pk: string(ItemTypeCount), sk: fmt.Sprintf("%s#%s", ItemTypeCount, it)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s count: %w", it, err)
+	}
+
+	return count, nil
+}
+
+// GetSubscriptionCount fetches the number of subscriptions with the given status.
+func (s *Store) GetSubscriptionCount(ctx context.Context, status SubscriptionStatus) (int64, error) {
+	keys := countKeys(&Subscription{Status: status})
+
+	count, err := s.getCount(ctx, keys[len(keys)-1])
+	if err != nil {
+		return 0, fmt.Errorf("failed to get %s subscription count: %w", status, err)
+	}
+
+	return count, nil
+}
+
+// ReconcileSubscriptionCount recomputes the subscription count items, in total and
+// per status, from the subscriptions in the table and returns the new total. The
+// counts can drift when subscriptions are deleted outside of the Store, such as when
+// DynamoDB expires pending subscriptions. Subscriptions created or deleted while the
+// table is being scanned may not be reflected until the next run.
+func (s *Store) ReconcileSubscriptionCount(ctx context.Context) (int64, error) {
+	// Start every count at zero so counts with no subscriptions left are reset.
+	counts := map[countKey]int64{}
+	for _, status := range []SubscriptionStatus{"", SubscriptionStatusPending, SubscriptionStatusConfirmed, SubscriptionStatusHeld} {
+		for _, k := range countKeys(&Subscription{Status: status}) {
+			counts[k] = 0
+		}
+	}
+
+	options := PageOptions{}
+	for {
+		subscriptions := []*Subscription{}
+		cursor, err := s.table.scanItems(ctx, ItemTypeSubscription, options, &subscriptions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan subscriptions: %w", err)
+		}
+
+		for _, subscription := range subscriptions {
+			for _, k := range countKeys(subscription) {
+				counts[k]++
+			}
+		}
+
+		if cursor == "" {
+			break
+		}
+		options.Cursor = cursor
+	}
+
+	for k, count := range counts {
+		if err := s.table.setCount(ctx, k.pk, k.sk, count); err != nil {
+			return 0, fmt.Errorf("failed to set subscription count: %w", err)
+		}
+	}
+
+	return counts[countKeys(&Subscription{})[0]], nil
+}
+
+// ExpireSubscription decrements the count items of a subscription that DynamoDB deleted
+// when it expired. DynamoDB deletes expired items without going through the Store so
+// their counts have to be moved separately, and the subscription must be the item as
+// it was before it was deleted.
+func (s *Store) ExpireSubscription(ctx context.Context, subscription *Subscription) error {
+	if err := s.table.adjustCounts(ctx, countDeltas(subscription, nil)); err != nil {
+		return fmt.Errorf("failed to decrement expired subscription counts: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) getCount(ctx context.Context, k countKey) (int64, error) {
+	count := &countItem{}
+	if err := s.table.getItem(ctx, k.pk, k.sk, count); err != nil {
+		return 0, err
+	}
+
+	return count.Count, nil
+}
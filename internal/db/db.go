@@ -10,16 +10,127 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 )
 
-var (
-	DynamoDBClient *dynamodb.Client
-	TableName      string
-)
+// SubscriptionStore persists subscriptions. Handlers should depend on this interface
+// rather than a concrete Store so they can be tested against NewMemoryStore.
+type SubscriptionStore interface {
+	// CreateSubscription creates a new subscription. The outbox entries are created in
+	// the same transaction.
+	CreateSubscription(ctx context.Context, subscription *Subscription, outbox ...*OutboxEntry) error
+	// DeleteSubscription deletes a subscription via its email address and ID. If the
+	// subscription doesn't exist ErrNotFound is returned. The outbox entries are created
+	// in the same transaction.
+	DeleteSubscription(ctx context.Context, emailAddress string, id string, outbox ...*OutboxEntry) error
+	// GetSubscription fetches a subscription via its email address. A nil subscription
+	// is returned if it doesn't exist.
+	GetSubscription(ctx context.Context, emailAddress string) (*Subscription, error)
+	// GetSubscriptions fetches every subscription.
+	GetSubscriptions(ctx context.Context) ([]*Subscription, error)
+	// GetSubscriptionsPage fetches a single page of subscriptions.
+	GetSubscriptionsPage(ctx context.Context, options PageOptions) (*SubscriptionPage, error)
+	// StreamSubscriptions sends every subscription on the returned channel, one page at
+	// a time.
+	StreamSubscriptions(ctx context.Context, pageSize int32) (<-chan *Subscription, <-chan error)
+	// UpdateSubscription updates an existing subscription. If the subscription doesn't
+	// exist ErrNotFound is returned. The outbox entries are created in the same
+	// transaction.
+	UpdateSubscription(ctx context.Context, subscription *Subscription, outbox ...*OutboxEntry) error
+	// GetCount fetches the number of items of the given type.
+	GetCount(ctx context.Context, it ItemType) (int64, error)
+	// GetSubscriptionCount fetches the number of subscriptions with the given status.
+	GetSubscriptionCount(ctx context.Context, status SubscriptionStatus) (int64, error)
+	// ReconcileSubscriptionCount recomputes the subscription count item from the
+	// subscriptions in the table.
+	ReconcileSubscriptionCount(ctx context.Context) (int64, error)
+	// ExpireSubscription decrements the count items of a subscription that DynamoDB
+	// deleted when it expired.
+	ExpireSubscription(ctx context.Context, subscription *Subscription) error
+}
+
+// DeliveryStore records which subscriptions a broadcast has been delivered to.
+type DeliveryStore interface {
+	// CreateDelivery records a delivery. If the broadcast has already been delivered to
+	// the subscription ErrAlreadyExists is returned.
+	CreateDelivery(ctx context.Context, delivery *Delivery) error
+	// DeleteDelivery deletes a delivery via its broadcast ID and subscription ID. If the
+	// delivery doesn't exist ErrNotFound is returned.
+	DeleteDelivery(ctx context.Context, broadcastID string, subscriptionID string) error
+	// GetDelivery fetches a delivery via its broadcast ID and subscription ID. If the
+	// broadcast hasn't been delivered to the subscription nil is returned.
+	GetDelivery(ctx context.Context, broadcastID string, subscriptionID string) (*Delivery, error)
+	// ExpireDelivery decrements the count item of a delivery that DynamoDB deleted when
+	// it expired.
+	ExpireDelivery(ctx context.Context, delivery *Delivery) error
+	// GetDeliveryCount fetches the number of subscriptions a broadcast has been
+	// delivered to.
+	GetDeliveryCount(ctx context.Context, broadcastID string) (int64, error)
+}
+
+// AlertStore keeps the alerts sent to the site owner in their daily digest.
+type AlertStore interface {
+	// CreateAlert records an alert for the next digest. If an alert with the same ID
+	// hasn't been sent yet, the alert is a repeat and isn't recorded again.
+	CreateAlert(ctx context.Context, alert *Alert) error
+	// GetAlerts fetches every alert that hasn't been deleted, oldest first.
+	GetAlerts(ctx context.Context) ([]*Alert, error)
+	// DeleteAlert deletes an alert once it has been sent. If the alert doesn't exist
+	// ErrNotFound is returned.
+	DeleteAlert(ctx context.Context, alert *Alert) error
+	// ExpireAlert decrements the count item of an alert that DynamoDB deleted when it
+	// expired.
+	ExpireAlert(ctx context.Context, alert *Alert) error
+}
+
+// AuditStore keeps the history of changes to subscriptions.
+type AuditStore interface {
+	// CreateAuditEntry records an audit entry. If an entry with the same ID has already
+	// been recorded it isn't recorded again.
+	CreateAuditEntry(ctx context.Context, entry *AuditEntry) error
+	// GetAuditEntries fetches every audit entry, oldest first.
+	GetAuditEntries(ctx context.Context) ([]*AuditEntry, error)
+}
+
+// OutboxStore reads the outbox entries written alongside subscription changes so a
+// relay can publish them.
+type OutboxStore interface {
+	// GetPendingOutboxEntries fetches every outbox entry that hasn't been published,
+	// oldest first.
+	GetPendingOutboxEntries(ctx context.Context) ([]*OutboxEntry, error)
+	// PublishOutboxEntry marks an outbox entry as published. If the entry doesn't exist
+	// ErrNotFound is returned.
+	PublishOutboxEntry(ctx context.Context, entry *OutboxEntry) error
+	// ExpireOutboxEntry decrements the count items of a published outbox entry that
+	// DynamoDB deleted when it expired.
+	ExpireOutboxEntry(ctx context.Context, entry *OutboxEntry) error
+}
+
+// SentEmailStore is the ledger of the emails that have been sent. It implements
+// notification.Ledger.
+type SentEmailStore interface {
+	// ClaimEmail records the idempotency key of an email that is about to be sent. It
+	// reports false if the key has already been claimed.
+	ClaimEmail(ctx context.Context, key string) (bool, error)
+	// ReleaseEmail deletes a claimed idempotency key so the email is sent when it's
+	// retried.
+	ReleaseEmail(ctx context.Context, key string) error
+	// ExpireSentEmail decrements the count item of a ledger record that DynamoDB deleted
+	// when it expired.
+	ExpireSentEmail(ctx context.Context, sentEmail *SentEmail) error
+}
+
+// Store implements SubscriptionStore, DeliveryStore, AlertStore, AuditStore,
+// OutboxStore and SentEmailStore on top of a table.
+// Use NewDynamoDBStore or NewMemoryStore to create one.
+type Store struct {
+	table table
+}
 
-func Initialize(ctx context.Context, profile string, region string, tableName string) error {
+// NewDynamoDBStore returns a Store backed by the DynamoDB table with the given name.
+// Both the profile and region parameters are optional if authentication can be
+// achieved via another method. For example, environment variables or IAM roles.
+func NewDynamoDBStore(ctx context.Context, profile string, region string, tableName string) (*Store, error) {
 	if len(tableName) == 0 {
-		return errors.New("table name cannot be empty")
+		return nil, errors.New("table name cannot be empty")
 	}
-	TableName = tableName
 
 	var cfg aws.Config
 	var err error
@@ -30,22 +141,22 @@ func Initialize(ctx context.Context, profile string, region string, tableName st
 		cfg, err = config.LoadDefaultConfig(ctx)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to load default config: %w", err)
+		return nil, fmt.Errorf("failed to load default config: %w", err)
 	}
 
-	DynamoDBClient = dynamodb.NewFromConfig(cfg)
-
-	return nil
+	return &Store{
+		table: &dynamoDBTable{
+			client:    dynamodb.NewFromConfig(cfg),
+			tableName: tableName,
+		},
+	}, nil
 }
 
-func checkPackage() error {
-	if DynamoDBClient == nil {
-		return errors.New("db.DynamoDBClient is nil, have you called db.Initialize()?")
-	}
-
-	if TableName == "" {
-		return errors.New("db.TableName is empty, did you call db.Initialize()?")
+// NewMemoryStore returns a Store backed by an in-memory table. It mirrors the
+// behaviour of the DynamoDB table, including the count items and Gsi1 lookups,
+// and is intended for tests and local development.
+func NewMemoryStore() *Store {
+	return &Store{
+		table: newMemoryTable(),
 	}
-
-	return nil
 }
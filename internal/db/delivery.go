@@ -0,0 +1,146 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// DeliveryTTL is how long a delivery is kept. Broadcasts are retried within minutes, a
+// post broadcast again after its deliveries expire is sent to everyone again.
+const DeliveryTTL = 90 * 24 * time.Hour
+
+// Delivery records that a broadcast was sent to a subscription. Deliveries are created
+// after the email is enqueued so a retried broadcast can skip the subscriptions it has
+// already been sent to.
+type Delivery struct {
+	BroadcastID    string    `json:"broadcastId" dynamodbav:"broadcastId"`
+	SubscriptionID string    `json:"subscriptionId" dynamodbav:"subscriptionId"`
+	EmailAddress   string    `json:"emailAddress" dynamodbav:"emailAddress"`
+	CreatedAt      time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is the Unix time in seconds at which DynamoDB will delete the delivery.
+	ExpiresAt int64 `json:"expiresAt" dynamodbav:"expiresAt"`
+}
+
+// NewDelivery returns a delivery of the broadcast to the subscription that expires
+// after DeliveryTTL.
+func NewDelivery(broadcastID string, subscription *Subscription) *Delivery {
+	now := time.Now().UTC()
+
+	return &Delivery{
+		BroadcastID:    broadcastID,
+		SubscriptionID: subscription.ID,
+		EmailAddress:   subscription.EmailAddress,
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(DeliveryTTL).Unix(),
+	}
+}
+
+// GetDelivery fetches a delivery via its broadcast ID and subscription ID. If the
+// broadcast hasn't been delivered to the subscription nil is returned.
+func (s *Store) GetDelivery(ctx context.Context, broadcastID string, subscriptionID string) (*Delivery, error) {
+	key := &Delivery{BroadcastID: broadcastID, SubscriptionID: subscriptionID}
+
+	var delivery *Delivery
+	if err := s.table.getItem(ctx, key.pk(), key.sk(), &delivery); err != nil {
+		return nil, fmt.Errorf("failed to get delivery: %w", err)
+	}
+
+	return delivery, nil
+}
+
+// CreateDelivery records a delivery. If the broadcast has already been delivered to the
+// subscription ErrAlreadyExists is returned.
+func (s *Store) CreateDelivery(ctx context.Context, delivery *Delivery) error {
+	if err := s.table.putItem(ctx, delivery, putIfNotExists); err != nil {
+		return fmt.Errorf("failed to create delivery: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteDelivery deletes a delivery via its broadcast ID and subscription ID so the
+// broadcast is sent to the subscription again when it's retried. If the delivery
+// doesn't exist ErrNotFound is returned.
+func (s *Store) DeleteDelivery(ctx context.Context, broadcastID string, subscriptionID string) error {
+	if err := s.table.deleteItem(ctx, &Delivery{BroadcastID: broadcastID, SubscriptionID: subscriptionID}); err != nil {
+		return fmt.Errorf("failed to delete delivery: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireDelivery decrements the count item of a delivery that DynamoDB deleted when it
+// expired.
+func (s *Store) ExpireDelivery(ctx context.Context, delivery *Delivery) error {
+	if err := s.table.adjustCounts(ctx, countDeltas(delivery, nil)); err != nil {
+		return fmt.Errorf("failed to decrement expired delivery count: %w", err)
+	}
+
+	return nil
+}
+
+// GetDeliveryCount fetches the number of subscriptions a broadcast has been delivered to.
+func (s *Store) GetDeliveryCount(ctx context.Context, broadcastID string) (int64, error) {
+	delivery := &Delivery{BroadcastID: broadcastID}
+
+	count, err := s.getCount(ctx, countKey{pk: delivery.countPK(), sk: delivery.countSK()})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get delivery count: %w", err)
+	}
+
+	return count, nil
+}
+
+func (d *Delivery) pk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeDelivery, d.BroadcastID)
+}
+
+func (d *Delivery) sk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeDelivery, d.SubscriptionID)
+}
+
+func (d *Delivery) countPK() string {
+	return string(ItemTypeCount)
+}
+
+// countSK counts deliveries per broadcast so a broadcast's progress can be checked.
+func (d *Delivery) countSK() string {
+	return fmt.Sprintf("%s#%s#%s", ItemTypeCount, d.itemType(), d.BroadcastID)
+}
+
+// gsiPK1 partitions deliveries per broadcast, like their primary key, so a broadcast to
+// every subscription doesn't write to a single index partition.
+func (d *Delivery) gsiPK1() string {
+	return d.pk()
+}
+
+func (d *Delivery) gsiSK1() string {
+	return fmt.Sprintf("%s#%s", d.CreatedAt.UTC().Format(sortableTimeFormat), d.SubscriptionID)
+}
+
+func (d *Delivery) itemType() ItemType {
+	return ItemTypeDelivery
+}
+
+// update only sets the email address and expiry as the other attributes of a delivery
+// are part of its keys.
+func (d *Delivery) update() expression.UpdateBuilder {
+	return expression.Set(expression.Name("emailAddress"), expression.Value(d.EmailAddress)).
+		Set(expression.Name("expiresAt"), expression.Value(d.ExpiresAt))
+}
+
+func (d *Delivery) validate() error {
+	if len(d.BroadcastID) == 0 {
+		return errors.New("broadcast id cannot be empty")
+	}
+
+	if len(d.SubscriptionID) == 0 {
+		return errors.New("subscription id cannot be empty")
+	}
+
+	return nil
+}
@@ -0,0 +1,527 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamoDBTable implements table using a DynamoDB table.
+type dynamoDBTable struct {
+	client    *dynamodb.Client
+	tableName string
+}
+
+// deleteItem deletes an item based on its primary key and sort key from the
+// DynamoDB table. If the item doesn't exist ErrNotFound is returned.
+func (d *dynamoDBTable) deleteItem(ctx context.Context, i item, puts ...item) error {
+	putItems, err := d.newPuts(puts)
+	if err != nil {
+		return err
+	}
+
+	expr, err := expression.NewBuilder().WithCondition(existingItemCondition(i)).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build condition expression: %w", err)
+	}
+
+	transactItems := []types.TransactWriteItem{
+		{
+			Delete: &types.Delete{
+				Key:                                 key(i.pk(), i.sk()),
+				TableName:                           aws.String(d.tableName),
+				ConditionExpression:                 expr.Condition(),
+				ExpressionAttributeNames:            expr.Names(),
+				ExpressionAttributeValues:           expr.Values(),
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			},
+		},
+	}
+	transactItems = append(transactItems, putItems...)
+	transactItems = append(transactItems, d.addCounts(putDeltas(countDeltas(i, nil), puts))...)
+
+	if u, ok := i.(uniqueItem); ok {
+		pk, sk := u.uniqueKey()
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Delete: &types.Delete{
+				Key:       key(pk, sk),
+				TableName: aws.String(d.tableName),
+			},
+		})
+	}
+
+	// Delete the item in a transaction so we can update the secondary items that track
+	// the number of this type of item in the DynamoDB table. The transaction is cancelled
+	// if the item doesn't exist so the counts are only decremented when something is
+	// deleted.
+	if _, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	}); err != nil {
+		return existingItemError(err)
+	}
+
+	return nil
+}
+
+// getItem fetches a single item based on its primary key and optionall sort key from
+// the DynamoDB table. If the sort key is present a get item call will be made to the
+// table, otherwise a query call will be used. The item parameter must be a non-nil
+// pointer to an object.
+func (d *dynamoDBTable) getItem(ctx context.Context, pk string, sk string, item interface{}) error {
+	var dbItem map[string]types.AttributeValue
+
+	if len(sk) == 0 {
+		expr, err := expression.NewBuilder().WithKeyCondition(
+			expression.KeyConditionBuilder(
+				expression.Key("pk").Equal(expression.Value(pk)),
+			),
+		).Build()
+		if err != nil {
+			return fmt.Errorf("failed to build query expression: %w", err)
+		}
+
+		output, err := d.client.Query(ctx, &dynamodb.QueryInput{
+			TableName:                 aws.String(d.tableName),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+			KeyConditionExpression:    expr.KeyCondition(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(output.Items) == 0 {
+			return nil
+		}
+		dbItem = output.Items[0]
+	} else {
+		output, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(d.tableName),
+			Key:       key(pk, sk),
+		})
+		if err != nil {
+			return err
+		}
+
+		dbItem = output.Item
+	}
+
+	if dbItem == nil {
+		return nil
+	}
+
+	if err := attributevalue.UnmarshalMap(dbItem, &item); err != nil {
+		return fmt.Errorf("failed to unmarshal item into interface: %w", err)
+	}
+
+	return nil
+}
+
+// getItems fetches a page of items based on their itemType from the DynamoDB
+// table and returns the cursor of the next page. The 'items' parameter must be a
+// non-nil pointer to a slice of elements that implement the item interface and
+// have their itemType equal the itemType of the 'it' parameter.
+func (d *dynamoDBTable) getItems(ctx context.Context, it ItemType, options PageOptions, items interface{}) (string, error) {
+	exclusiveStartKey, err := decodeCursor(options.Cursor)
+	if err != nil {
+		return "", err
+	}
+
+	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("gsiPk1").Equal(expression.Value(it))).Build()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := d.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(d.tableName),
+		ExclusiveStartKey:         exclusiveStartKey,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		IndexName:                 aws.String("Gsi1"),
+		KeyConditionExpression:    expr.KeyCondition(),
+		Limit:                     aws.Int32(options.limit()),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+		return "", fmt.Errorf("failed to unmarshal items into slice: %w", err)
+	}
+
+	return encodeCursor(output.LastEvaluatedKey)
+}
+
+// scanItems fetches a page of items based on their itemType by scanning the
+// DynamoDB table and returns the cursor of the next page. Pages can be empty
+// when none of the scanned items match the itemType.
+func (d *dynamoDBTable) scanItems(ctx context.Context, it ItemType, options PageOptions, items interface{}) (string, error) {
+	exclusiveStartKey, err := decodeCursor(options.Cursor)
+	if err != nil {
+		return "", err
+	}
+
+	expr, err := expression.NewBuilder().WithFilter(expression.Name("itemType").Equal(expression.Value(it))).Build()
+	if err != nil {
+		return "", err
+	}
+
+	output, err := d.client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:                 aws.String(d.tableName),
+		ExclusiveStartKey:         exclusiveStartKey,
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		Limit:                     aws.Int32(options.limit()),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
+		return "", fmt.Errorf("failed to unmarshal items into slice: %w", err)
+	}
+
+	return encodeCursor(output.LastEvaluatedKey)
+}
+
+// putItem inserts a new item into the DynamoDB table. If the condition is
+// putIfNotExists and the item or its marker already exist, ErrAlreadyExists is
+// returned and nothing is written.
+func (d *dynamoDBTable) putItem(ctx context.Context, i item, condition putCondition, puts ...item) error {
+	if err := i.validate(); err != nil {
+		return fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
+	}
+
+	attributeValues, err := marshalItem(i)
+	if err != nil {
+		return err
+	}
+
+	transactItems, err := d.newPuts(puts)
+	if err != nil {
+		return err
+	}
+	put := func(item map[string]types.AttributeValue) error {
+		p, err := d.newPut(item, condition)
+		if err != nil {
+			return err
+		}
+
+		transactItems = append(transactItems, types.TransactWriteItem{Put: p})
+		return nil
+	}
+
+	if err := put(attributeValues); err != nil {
+		return err
+	}
+	if u, ok := i.(uniqueItem); ok {
+		markerCondition := condition
+		if condition == putIfNotExists {
+			markerCondition = putIfNotExistsOrExpired
+		}
+
+		p, err := d.newPut(marshalMarker(u, attributeValues), markerCondition)
+		if err != nil {
+			return err
+		}
+		transactItems = append(transactItems, types.TransactWriteItem{Put: p})
+	}
+
+	// Create the item in a transaction so we can update the secondary items that track the
+	// number of this type of item in the DynamoDB table. If a condition fails the whole
+	// transaction is cancelled so the counts are only updated when the item is written.
+	if _, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: append(transactItems, d.addCounts(putDeltas(countDeltas(nil, i), puts))...),
+	}); err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+
+	return nil
+}
+
+// updateItem updates an existing item in the DynamoDB table. If the item is a
+// uniqueItem its marker's expiry is updated in the same transaction.
+func (d *dynamoDBTable) updateItem(ctx context.Context, i item, previous item, puts ...item) error {
+	if err := i.validate(); err != nil {
+		return fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
+	}
+
+	putItems, err := d.newPuts(puts)
+	if err != nil {
+		return err
+	}
+
+	condition := i
+	if previous != nil {
+		condition = previous
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(itemUpdate(i)).WithCondition(existingItemCondition(condition)).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build update expression: %w", err)
+	}
+
+	transactItems := []types.TransactWriteItem{
+		{
+			Update: &types.Update{
+				Key:                                 key(i.pk(), i.sk()),
+				TableName:                           aws.String(d.tableName),
+				ConditionExpression:                 expr.Condition(),
+				ExpressionAttributeNames:            expr.Names(),
+				ExpressionAttributeValues:           expr.Values(),
+				UpdateExpression:                    expr.Update(),
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			},
+		},
+	}
+
+	deltas := map[countKey]int64{}
+	if previous != nil {
+		deltas = countDeltas(previous, i)
+	}
+	transactItems = append(transactItems, putItems...)
+	transactItems = append(transactItems, d.addCounts(putDeltas(deltas, puts))...)
+
+	if u, ok := i.(uniqueItem); ok {
+		attributeValues, err := marshalItem(i)
+		if err != nil {
+			return err
+		}
+
+		// Set the marker's item type too so markers are created for items written before
+		// they had one.
+		markerUpdate := expression.Set(expression.Name("itemType"), expression.Value(ItemTypeUnique))
+		if expiresAt, ok := attributeValues["expiresAt"]; ok {
+			markerUpdate = markerUpdate.Set(expression.Name("expiresAt"), expression.Value(expiresAt))
+		} else {
+			markerUpdate = markerUpdate.Remove(expression.Name("expiresAt"))
+		}
+		markerExpr, err := expression.NewBuilder().WithUpdate(markerUpdate).Build()
+		if err != nil {
+			return fmt.Errorf("failed to build marker update expression: %w", err)
+		}
+		markerPK, markerSK := u.uniqueKey()
+
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Update: &types.Update{
+				Key:                       key(markerPK, markerSK),
+				TableName:                 aws.String(d.tableName),
+				ExpressionAttributeNames:  markerExpr.Names(),
+				ExpressionAttributeValues: markerExpr.Values(),
+				UpdateExpression:          markerExpr.Update(),
+			},
+		})
+	}
+
+	if _, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: transactItems,
+	}); err != nil {
+		return existingItemError(err)
+	}
+
+	return nil
+}
+
+// setCount sets the count attribute of the count item with the given keys.
+func (d *dynamoDBTable) setCount(ctx context.Context, pk string, sk string, count int64) error {
+	if _, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:              key(pk, sk),
+		TableName:        aws.String(d.tableName),
+		UpdateExpression: aws.String("SET #count = :count"),
+		ExpressionAttributeNames: map[string]string{
+			"#count": "count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":count": &types.AttributeValueMemberN{
+				Value: strconv.FormatInt(count, 10),
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// adjustCounts adds each delta to its count item in a single transaction.
+func (d *dynamoDBTable) adjustCounts(ctx context.Context, deltas map[countKey]int64) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	if _, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: d.addCounts(deltas),
+	}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// addCounts returns the transaction items that add each delta to its count item,
+// creating the count items that don't exist.
+func (d *dynamoDBTable) addCounts(deltas map[countKey]int64) []types.TransactWriteItem {
+	transactItems := []types.TransactWriteItem{}
+	for k, delta := range deltas {
+		transactItems = append(transactItems, types.TransactWriteItem{
+			Update: &types.Update{
+				Key:              key(k.pk, k.sk),
+				TableName:        aws.String(d.tableName),
+				UpdateExpression: aws.String("ADD #count :count"),
+				ExpressionAttributeNames: map[string]string{
+					"#count": "count",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":count": &types.AttributeValueMemberN{
+						Value: strconv.FormatInt(delta, 10),
+					},
+				},
+			},
+		})
+	}
+
+	return transactItems
+}
+
+// newPut returns the put of an item's attribute values. If the condition is
+// putIfNotExists the put fails if an item with the same keys exists and if it's
+// putIfNotExistsOrExpired it fails if one exists that hasn't expired.
+func (d *dynamoDBTable) newPut(attributeValues map[string]types.AttributeValue, condition putCondition) (*types.Put, error) {
+	p := &types.Put{
+		Item:      attributeValues,
+		TableName: aws.String(d.tableName),
+	}
+
+	var cond expression.ConditionBuilder
+	switch condition {
+	case putIfNotExists:
+		cond = expression.AttributeNotExists(expression.Name("pk"))
+	case putIfNotExistsOrExpired:
+		// Items without an expiresAt attribute never match the comparison.
+		cond = expression.AttributeNotExists(expression.Name("pk")).
+			Or(expression.Name("expiresAt").LessThan(expression.Value(time.Now().Unix())))
+	default:
+		return p, nil
+	}
+
+	expr, err := expression.NewBuilder().WithCondition(cond).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build condition expression: %w", err)
+	}
+	p.ConditionExpression = expr.Condition()
+	p.ExpressionAttributeNames = expr.Names()
+	p.ExpressionAttributeValues = expr.Values()
+
+	return p, nil
+}
+
+// newPuts returns the transaction items that create each of the items written
+// alongside another write, if they don't exist.
+func (d *dynamoDBTable) newPuts(puts []item) ([]types.TransactWriteItem, error) {
+	transactItems := []types.TransactWriteItem{}
+	for _, i := range puts {
+		if err := i.validate(); err != nil {
+			return nil, fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
+		}
+
+		attributeValues, err := marshalItem(i)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := d.newPut(attributeValues, putIfNotExists)
+		if err != nil {
+			return nil, err
+		}
+		transactItems = append(transactItems, types.TransactWriteItem{Put: p})
+	}
+
+	return transactItems, nil
+}
+
+// existingItemCondition returns the condition a stored item must meet to be updated
+// or deleted. It must exist and, for a groupedCountItem, still be in the same group.
+func existingItemCondition(i item) expression.ConditionBuilder {
+	condition := expression.AttributeExists(expression.Name("pk"))
+
+	if g, ok := i.(groupedCountItem); ok {
+		name, value := g.countGroup()
+		if value == "" {
+			condition = condition.And(expression.AttributeNotExists(expression.Name(name)))
+		} else {
+			condition = condition.And(expression.Name(name).Equal(expression.Value(value)))
+		}
+	}
+
+	return condition
+}
+
+// existingItemError maps the error from a write conditioned on existingItemCondition,
+// which must be the first item of the transaction. If the stored item was returned
+// with the failure it exists but changed, otherwise it doesn't exist. A failure of a
+// later item is one of the puts written alongside it already existing.
+func existingItemError(err error) error {
+	var transactionCanceled *types.TransactionCanceledException
+	if !errors.As(err, &transactionCanceled) {
+		return err
+	}
+
+	for index, reason := range transactionCanceled.CancellationReasons {
+		if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+			continue
+		}
+
+		if index > 0 {
+			return ErrAlreadyExists
+		}
+
+		if len(reason.Item) == 0 {
+			return ErrNotFound
+		}
+		return errConflict
+	}
+
+	return err
+}
+
+// key returns the primary key attribute values of an item.
+func key(pk string, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": &types.AttributeValueMemberS{Value: pk},
+		"sk": &types.AttributeValueMemberS{Value: sk},
+	}
+}
+
+// isConditionalCheckFailed reports whether the error was caused by a condition
+// expression not holding, either in a single request or in a transaction.
+func isConditionalCheckFailed(err error) bool {
+	var conditionalCheckFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionalCheckFailed) {
+		return true
+	}
+
+	var transactionCanceled *types.TransactionCanceledException
+	if !errors.As(err, &transactionCanceled) {
+		return false
+	}
+
+	for _, reason := range transactionCanceled.CancellationReasons {
+		if aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,22 +2,65 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
-	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
-type itemType string
+// ItemType is the type of an item stored in the table.
+type ItemType string
 
 const (
-	itemTypeSubscription itemType = "SUBSCRIPTION"
-	itemTypeCount        itemType = "COUNT"
+	ItemTypeSubscription ItemType = "SUBSCRIPTION"
+	ItemTypeCount        ItemType = "COUNT"
+	ItemTypeUnique       ItemType = "UNIQUE"
+	ItemTypeDelivery     ItemType = "DELIVERY"
+	ItemTypeAlert        ItemType = "ALERT"
+	ItemTypeSentEmail    ItemType = "SENT_EMAIL"
+	ItemTypeOutbox       ItemType = "OUTBOX"
+	ItemTypeAudit        ItemType = "AUDIT"
 )
 
+var (
+	// ErrAlreadyExists is returned when an item can't be created because it, or an item
+	// with the same unique key, already exists.
+	ErrAlreadyExists = errors.New("item already exists")
+	// ErrNotFound is returned when an item can't be updated or deleted because it
+	// doesn't exist.
+	ErrNotFound = errors.New("item not found")
+
+	// errConflict is returned when an item can't be updated or deleted because the
+	// attribute it's grouped by in its counts changed since it was read. The caller
+	// should read the item again and retry.
+	errConflict = errors.New("item changed since it was read")
+)
+
+// maxConflictRetries is the number of times a write that failed with errConflict is
+// retried before giving up.
+const maxConflictRetries = 3
+
+// putCondition is a condition that must hold for putItem to write an item.
+type putCondition int
+
+const (
+	// putAlways writes the item, replacing any existing item with the same keys.
+	putAlways putCondition = iota
+	// putIfNotExists only writes the item if neither it nor, for a uniqueItem, an item
+	// with the same unique key exists. ErrAlreadyExists is returned otherwise.
+	putIfNotExists
+	// putIfNotExistsOrExpired is putIfNotExists but also replaces an item that is past
+	// its expiresAt and is only waiting to be deleted by DynamoDB's time to live. It's
+	// used for markers, which are deleted independently of their item.
+	putIfNotExistsOrExpired
+)
+
+// sortableTimeFormat is a fixed width RFC 3339 format used for times in sort keys so
+// they sort lexicographically in time order.
+const sortableTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
 // item represents an item in the DynamoDB table. If implementing this interface,
 // be sure to add the 'dynamodbav' tags to the struct's properties.
 type item interface {
@@ -29,234 +72,173 @@ type item interface {
 	countPK() string
 	// Returns the sort key of the secondary item that keeps a count of the main item.
 	countSK() string
+	// Returns the partition key of the item in the Gsi1 index.
+	gsiPK1() string
+	// Returns the sort key of the item in the Gsi1 index. It should sort the items in
+	// the order they are expected to be listed, such as by creation time.
+	gsiSK1() string
 	// Returns the type of the item.
-	itemType() itemType
-	// Returns the expression to update the item.
-	updateExpression() (expression.Expression, error)
+	itemType() ItemType
+	// Returns the update to apply to the item. The index keys are added to it when the
+	// item is updated so they don't need to be included.
+	update() expression.UpdateBuilder
 	// Validates the items properties.
 	validate() error
 }
 
-// deleteItem deletes an item based on its primary key and sort key from the
-// DynamoDB table.
-func deleteItem(ctx context.Context, it itemType, pk string, sk string) error {
-	if err := checkPackage(); err != nil {
-		return err
-	}
-
-	// Delete the item in a transaction so we can update a secondary item that tracks the
-	// number of this type of item in the DynamoDB table.
-	if _, err := DynamoDBClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: []types.TransactWriteItem{
-			{
-				Delete: &types.Delete{
-					Key: map[string]types.AttributeValue{
-						"pk": &types.AttributeValueMemberS{Value: pk},
-						"sk": &types.AttributeValueMemberS{Value: sk},
-					},
-					TableName: aws.String(TableName),
-				},
-			},
-			{
-				Update: &types.Update{
-					Key: map[string]types.AttributeValue{
-						"pk": &types.AttributeValueMemberS{Value: string(itemTypeCount)},
-						"sk": &types.AttributeValueMemberS{Value: fmt.Sprintf("%s#%s", itemTypeCount, it)},
-					},
-					TableName:        aws.String(TableName),
-					UpdateExpression: aws.String("ADD #count :count"),
-					ExpressionAttributeNames: map[string]string{
-						"#count": "count",
-					},
-					ExpressionAttributeValues: map[string]types.AttributeValue{
-						":count": &types.AttributeValueMemberN{
-							Value: "-1",
-						},
-					},
-				},
-			},
-		},
-	}); err != nil {
-		return err
-	}
+// uniqueItem is implemented by items that must be unique on something other than
+// their primary and sort key. A marker item is written under the unique key in the
+// same transaction as the item so two items with the same unique key can't both be
+// created. The marker mirrors the item's expiresAt attribute so it expires with the
+// item. DynamoDB's time to live can delete the marker long after the item, so an
+// expired marker doesn't stop a new item from being created.
+type uniqueItem interface {
+	item
+	// Returns the primary key and sort key of the item's marker.
+	uniqueKey() (string, string)
+}
 
-	return nil
+// groupedCountItem is implemented by items that also keep a count per value of one of
+// their attributes, such as subscriptions per status. Updates and deletes of these
+// items are conditional on the attribute still having the value it was read with so
+// the counts can be moved atomically.
+type groupedCountItem interface {
+	item
+	// Returns the name and value of the attribute the item's counts are grouped by.
+	countGroup() (string, string)
 }
 
-// getItem fetches a single item based on its primary key and optionall sort key from
-// the DynamoDB table. If the sort key is present a get item call will be made to the
-// table, otherwise a query call will be used. The item parameter must be a non-nil
-// pointer to an object.
-func getItem(ctx context.Context, pk string, sk string, item interface{}) error {
-	if err := checkPackage(); err != nil {
-		return err
-	}
+// countKey is the key of a count item.
+type countKey struct {
+	pk string
+	sk string
+}
 
-	var dbItem map[string]types.AttributeValue
+// countKeys returns the keys of every count item an item is counted in. For a
+// groupedCountItem the group's count item has the group's value appended to the
+// count sort key.
+func countKeys(i item) []countKey {
+	keys := []countKey{{pk: i.countPK(), sk: i.countSK()}}
 
-	if len(sk) == 0 {
-		expr, err := expression.NewBuilder().WithKeyCondition(
-			expression.KeyConditionBuilder(
-				expression.Key("pk").Equal(expression.Value(pk)),
-			),
-		).Build()
-		if err != nil {
-			return fmt.Errorf("failed to build query expression: %w", err)
+	if g, ok := i.(groupedCountItem); ok {
+		if _, value := g.countGroup(); value != "" {
+			keys = append(keys, countKey{pk: i.countPK(), sk: fmt.Sprintf("%s#%s", i.countSK(), value)})
 		}
+	}
 
-		output, err := DynamoDBClient.Query(ctx, &dynamodb.QueryInput{
-			TableName:                 aws.String(TableName),
-			ExpressionAttributeNames:  expr.Names(),
-			ExpressionAttributeValues: expr.Values(),
-			KeyConditionExpression:    expr.KeyCondition(),
-		})
-		if err != nil {
-			return err
-		}
+	return keys
+}
 
-		if len(output.Items) == 0 {
-			return nil
+// countDeltas returns the change to each count item when an item changes from
+// previous to next. Either can be nil for creates and deletes.
+func countDeltas(previous item, next item) map[countKey]int64 {
+	deltas := map[countKey]int64{}
+	if previous != nil {
+		for _, k := range countKeys(previous) {
+			deltas[k]--
 		}
-		dbItem = output.Items[0]
-	} else {
-		output, err := DynamoDBClient.GetItem(ctx, &dynamodb.GetItemInput{
-			TableName: aws.String(TableName),
-			Key: map[string]types.AttributeValue{
-				"pk": &types.AttributeValueMemberS{Value: pk},
-				"sk": &types.AttributeValueMemberS{Value: sk},
-			},
-		})
-		if err != nil {
-			return err
-		}
-
-		dbItem = output.Item
 	}
-
-	if dbItem == nil {
-		return nil
+	if next != nil {
+		for _, k := range countKeys(next) {
+			deltas[k]++
+		}
 	}
 
-	if err := attributevalue.UnmarshalMap(dbItem, &item); err != nil {
-		return fmt.Errorf("failed to unmarshal item into interface: %w", err)
+	for k, delta := range deltas {
+		if delta == 0 {
+			delete(deltas, k)
+		}
 	}
 
-	return nil
+	return deltas
 }
 
-// getItems fetches a slice of items based on their itemType from the DynamoDB
-// table. The 'items' parameter must be a non-nil pointer to a slice of elements
-// that implement the item interface and have their itemType equal the itemType
-// of the 'it' parameter.
-func getItems(ctx context.Context, it itemType, items interface{}) error {
-	if err := checkPackage(); err != nil {
-		return err
-	}
-
-	expr, err := expression.NewBuilder().WithKeyCondition(expression.Key("gsiPk1").Equal(expression.Value(it))).Build()
-	if err != nil {
-		return err
-	}
-
-	output, err := DynamoDBClient.Query(ctx, &dynamodb.QueryInput{
-		TableName:                 aws.String(TableName),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		IndexName:                 aws.String("Gsi1"),
-		KeyConditionExpression:    expr.KeyCondition(),
-	})
-	if err != nil {
-		return err
-	}
-
-	if err := attributevalue.UnmarshalListOfMaps(output.Items, &items); err != nil {
-		return fmt.Errorf("failed to unmarshal items into slice: %w", err)
+// putDeltas adds the count changes of creating each of the items to deltas, so items
+// written in the same transaction update each count item once.
+func putDeltas(deltas map[countKey]int64, puts []item) map[countKey]int64 {
+	for _, put := range puts {
+		for k, delta := range countDeltas(nil, put) {
+			deltas[k] += delta
+		}
 	}
 
-	return nil
+	return deltas
 }
 
-// putItem inserts a new item into the DynamoDB table.
-func putItem(ctx context.Context, i item) error {
-	if err := checkPackage(); err != nil {
-		return err
-	}
-
-	if err := i.validate(); err != nil {
-		return fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
-	}
+// table is the storage a Store reads and writes items through. The write methods
+// accept puts, items that are created in the same transaction as the write, such as
+// outbox entries. Each put is only created if it doesn't exist and is counted like
+// any other new item. If one already exists ErrAlreadyExists is returned and nothing
+// is written.
+type table interface {
+	// deleteItem deletes an item, and its marker if it's a uniqueItem, based on its
+	// primary key and sort key and decrements its count items. If the item doesn't
+	// exist ErrNotFound is returned and nothing is written. For a groupedCountItem, i
+	// must be the item as it was read so errConflict can be returned if it changed.
+	deleteItem(ctx context.Context, i item, puts ...item) error
+	// getItem fetches a single item based on its primary key and optional sort key. If
+	// the sort key is empty the first item in the partition is returned. The item
+	// parameter must be a non-nil pointer to an object.
+	getItem(ctx context.Context, pk string, sk string, item interface{}) error
+	// getItems fetches a page of items based on their itemType and returns the cursor of
+	// the next page, which is empty if there are no more pages. The 'items' parameter
+	// must be a non-nil pointer to a slice of elements that implement the item interface
+	// and have their itemType equal the itemType of the 'it' parameter.
+	getItems(ctx context.Context, it ItemType, options PageOptions, items interface{}) (string, error)
+	// scanItems fetches a page of items based on their itemType by scanning the whole
+	// table rather than using the index. It should only be used for maintenance tasks
+	// that need to find items the index may be missing.
+	scanItems(ctx context.Context, it ItemType, options PageOptions, items interface{}) (string, error)
+	// putItem inserts a new item if the condition holds and increments its count item.
+	putItem(ctx context.Context, i item, condition putCondition, puts ...item) error
+	// updateItem updates an existing item and keeps its index keys in sync. If the item
+	// doesn't exist ErrNotFound is returned. For a groupedCountItem, previous must be
+	// the item as it was read. The counts are moved if the group changed, and
+	// errConflict is returned if the stored item's group no longer matches previous.
+	updateItem(ctx context.Context, i item, previous item, puts ...item) error
+	// setCount sets the count attribute of the count item with the given keys.
+	setCount(ctx context.Context, pk string, sk string, count int64) error
+	// adjustCounts adds each delta to its count item in a single write, creating the
+	// count items that don't exist.
+	adjustCounts(ctx context.Context, deltas map[countKey]int64) error
+}
 
+// marshalItem marshals an item into the attribute values stored in the table,
+// including its key attributes.
+func marshalItem(i item) (map[string]types.AttributeValue, error) {
 	attributeValues, err := attributevalue.MarshalMap(i)
 	if err != nil {
-		return fmt.Errorf("failed to marshal account into attribute values: %w", err)
+		return nil, fmt.Errorf("failed to marshal %s into attribute values: %w", i.itemType(), err)
 	}
 	attributeValues["pk"] = &types.AttributeValueMemberS{Value: i.pk()}
 	attributeValues["sk"] = &types.AttributeValueMemberS{Value: i.sk()}
 	attributeValues["itemType"] = &types.AttributeValueMemberS{Value: string(i.itemType())}
+	attributeValues["gsiPk1"] = &types.AttributeValueMemberS{Value: i.gsiPK1()}
+	attributeValues["gsiSk1"] = &types.AttributeValueMemberS{Value: i.gsiSK1()}
 
-	// Create the item in a transaction so we can update a secondary item that tracks the
-	// number of this type of item in the DynamoDB table.
-	if _, err := DynamoDBClient.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
-		TransactItems: []types.TransactWriteItem{
-			{
-				Put: &types.Put{
-					Item:      attributeValues,
-					TableName: aws.String(TableName),
-				},
-			},
-			{
-				Update: &types.Update{
-					Key: map[string]types.AttributeValue{
-						"pk": &types.AttributeValueMemberS{Value: i.countPK()},
-						"sk": &types.AttributeValueMemberS{Value: i.countSK()},
-					},
-					TableName:        aws.String(TableName),
-					UpdateExpression: aws.String("ADD #count :count"),
-					ExpressionAttributeNames: map[string]string{
-						"#count": "count",
-					},
-					ExpressionAttributeValues: map[string]types.AttributeValue{
-						":count": &types.AttributeValueMemberN{
-							Value: "1",
-						},
-					},
-				},
-			},
-		},
-	}); err != nil {
-		return err
-	}
-
-	return nil
+	return attributeValues, nil
 }
 
-// updateItem updates an existing item in the DynamoDB table.
-func updateItem(ctx context.Context, i item) error {
-	if err := checkPackage(); err != nil {
-		return err
-	}
-
-	if err := i.validate(); err != nil {
-		return fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
-	}
+// itemUpdate returns the item's update expression, setting its index keys too so
+// they're kept in sync.
+func itemUpdate(i item) expression.UpdateBuilder {
+	return i.update().
+		Set(expression.Name("gsiPk1"), expression.Value(i.gsiPK1())).
+		Set(expression.Name("gsiSk1"), expression.Value(i.gsiSK1()))
+}
 
-	expr, err := i.updateExpression()
-	if err != nil {
-		return err
+// marshalMarker returns the attribute values of a uniqueItem's marker. The item's
+// attribute values are used to copy its expiry.
+func marshalMarker(i uniqueItem, attributeValues map[string]types.AttributeValue) map[string]types.AttributeValue {
+	pk, sk := i.uniqueKey()
+	marker := map[string]types.AttributeValue{
+		"pk":       &types.AttributeValueMemberS{Value: pk},
+		"sk":       &types.AttributeValueMemberS{Value: sk},
+		"itemType": &types.AttributeValueMemberS{Value: string(ItemTypeUnique)},
 	}
-
-	if _, err := DynamoDBClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
-		Key: map[string]types.AttributeValue{
-			"pk": &types.AttributeValueMemberS{Value: i.pk()},
-			"sk": &types.AttributeValueMemberS{Value: i.sk()},
-		},
-		TableName:                 aws.String(TableName),
-		ExpressionAttributeNames:  expr.Names(),
-		ExpressionAttributeValues: expr.Values(),
-		UpdateExpression:          expr.Update(),
-	}); err != nil {
-		return err
+	if expiresAt, ok := attributeValues["expiresAt"]; ok {
+		marker["expiresAt"] = expiresAt
 	}
 
-	return nil
+	return marker
 }
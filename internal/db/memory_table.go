@@ -0,0 +1,505 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// memoryTable implements table in memory. Items are stored as the same attribute
+// values that would be written to DynamoDB so reads unmarshal exactly as they
+// would from the real table.
+type memoryTable struct {
+	mutex sync.Mutex
+	// items maps a primary key to a sort key to the item's attribute values.
+	items map[string]map[string]map[string]types.AttributeValue
+}
+
+func newMemoryTable() *memoryTable {
+	return &memoryTable{
+		items: map[string]map[string]map[string]types.AttributeValue{},
+	}
+}
+
+// deleteItem deletes an item based on its primary key and sort key and decrements
+// its count items. If the item doesn't exist ErrNotFound is returned.
+func (m *memoryTable) deleteItem(ctx context.Context, i item, puts ...item) error {
+	putValues, err := marshalPuts(puts)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if err := m.checkExistingItem(i); err != nil {
+		return err
+	}
+	if err := m.checkPuts(puts); err != nil {
+		return err
+	}
+
+	delete(m.items[i.pk()], i.sk())
+	m.setPuts(puts, putValues)
+	m.addCounts(putDeltas(countDeltas(i, nil), puts))
+
+	if u, ok := i.(uniqueItem); ok {
+		pk, sk := u.uniqueKey()
+		delete(m.items[pk], sk)
+	}
+
+	return nil
+}
+
+// getItem fetches a single item based on its primary key and optional sort key. If
+// the sort key is empty the item with the lowest sort key in the partition is
+// returned, matching the order of a DynamoDB query.
+func (m *memoryTable) getItem(ctx context.Context, pk string, sk string, item interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var dbItem map[string]types.AttributeValue
+
+	if len(sk) == 0 {
+		sortKeys := m.sortedSortKeys(pk)
+		if len(sortKeys) == 0 {
+			return nil
+		}
+		dbItem = m.items[pk][sortKeys[0]]
+	} else {
+		dbItem = m.items[pk][sk]
+	}
+
+	if dbItem == nil {
+		return nil
+	}
+
+	if err := attributevalue.UnmarshalMap(dbItem, &item); err != nil {
+		return fmt.Errorf("failed to unmarshal item into interface: %w", err)
+	}
+
+	return nil
+}
+
+// getItems fetches a page of items whose gsiPk1 attribute equals the itemType,
+// mirroring a query on the Gsi1 index, and returns the cursor of the next page.
+func (m *memoryTable) getItems(ctx context.Context, it ItemType, options PageOptions, items interface{}) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return paginate(m.indexItems(it), indexKey, indexOrder, options, items)
+}
+
+// scanItems fetches a page of items whose itemType attribute equals the itemType in
+// table order and returns the cursor of the next page.
+func (m *memoryTable) scanItems(ctx context.Context, it ItemType, options PageOptions, items interface{}) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	dbItems := []map[string]types.AttributeValue{}
+	for _, pk := range m.sortedPrimaryKeys() {
+		for _, sk := range m.sortedSortKeys(pk) {
+			if attributeString(m.items[pk][sk], "itemType") == string(it) {
+				dbItems = append(dbItems, m.items[pk][sk])
+			}
+		}
+	}
+
+	return paginate(dbItems, tableKey, tableOrder, options, items)
+}
+
+// putItem inserts a new item if the condition holds and increments its count item.
+func (m *memoryTable) putItem(ctx context.Context, i item, condition putCondition, puts ...item) error {
+	if err := i.validate(); err != nil {
+		return fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
+	}
+
+	attributeValues, err := marshalItem(i)
+	if err != nil {
+		return err
+	}
+	putValues, err := marshalPuts(puts)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	u, isUnique := i.(uniqueItem)
+
+	if condition == putIfNotExists {
+		if m.items[i.pk()][i.sk()] != nil {
+			return ErrAlreadyExists
+		}
+		if isUnique {
+			if pk, sk := u.uniqueKey(); m.items[pk][sk] != nil && !isExpired(m.items[pk][sk]) {
+				return ErrAlreadyExists
+			}
+		}
+	}
+
+	if err := m.checkPuts(puts); err != nil {
+		return err
+	}
+
+	m.setItem(i.pk(), i.sk(), attributeValues)
+	if isUnique {
+		pk, sk := u.uniqueKey()
+		m.setItem(pk, sk, marshalMarker(u, attributeValues))
+	}
+	m.setPuts(puts, putValues)
+	m.addCounts(putDeltas(countDeltas(nil, i), puts))
+
+	return nil
+}
+
+// updateItem updates an item by applying its update expression to the stored item's
+// attributes, so attributes the expression doesn't set are kept as they would be by
+// DynamoDB.
+func (m *memoryTable) updateItem(ctx context.Context, i item, previous item, puts ...item) error {
+	if err := i.validate(); err != nil {
+		return fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(itemUpdate(i)).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build update expression: %w", err)
+	}
+	putValues, err := marshalPuts(puts)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	condition := i
+	if previous != nil {
+		condition = previous
+	}
+	if err := m.checkExistingItem(condition); err != nil {
+		return err
+	}
+	if err := m.checkPuts(puts); err != nil {
+		return err
+	}
+
+	attributeValues, err := applyUpdate(m.items[i.pk()][i.sk()], expr)
+	if err != nil {
+		return err
+	}
+	m.setItem(i.pk(), i.sk(), attributeValues)
+
+	deltas := map[countKey]int64{}
+	if previous != nil {
+		deltas = countDeltas(previous, i)
+	}
+	m.setPuts(puts, putValues)
+	m.addCounts(putDeltas(deltas, puts))
+
+	if u, ok := i.(uniqueItem); ok {
+		pk, sk := u.uniqueKey()
+		m.setItem(pk, sk, marshalMarker(u, attributeValues))
+	}
+
+	return nil
+}
+
+// applyUpdate returns a copy of the attribute values with the update expression's SET
+// and REMOVE actions applied. Only the "#name = :value" form of SET is supported as
+// it's the only one the items use.
+func applyUpdate(attributeValues map[string]types.AttributeValue, expr expression.Expression) (map[string]types.AttributeValue, error) {
+	updated := make(map[string]types.AttributeValue, len(attributeValues))
+	for name, value := range attributeValues {
+		updated[name] = value
+	}
+
+	names := expr.Names()
+	values := expr.Values()
+
+	for _, clause := range strings.Split(strings.TrimSpace(aws.ToString(expr.Update())), "\n") {
+		fields := strings.SplitN(clause, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid update clause: %q", clause)
+		}
+
+		for _, action := range strings.Split(fields[1], ", ") {
+			switch fields[0] {
+			case "SET":
+				operands := strings.Split(action, " = ")
+				if len(operands) != 2 || names[operands[0]] == "" || values[operands[1]] == nil {
+					return nil, fmt.Errorf("unsupported SET action: %q", action)
+				}
+				updated[names[operands[0]]] = values[operands[1]]
+			case "REMOVE":
+				if names[action] == "" {
+					return nil, fmt.Errorf("unsupported REMOVE action: %q", action)
+				}
+				delete(updated, names[action])
+			default:
+				return nil, fmt.Errorf("unsupported update clause: %q", clause)
+			}
+		}
+	}
+
+	return updated, nil
+}
+
+// checkExistingItem returns ErrNotFound if the item doesn't exist or errConflict if
+// it's a groupedCountItem and the stored item is in a different group, mirroring
+// the condition used by the DynamoDB table. The mutex must be held by the caller.
+func (m *memoryTable) checkExistingItem(i item) error {
+	stored := m.items[i.pk()][i.sk()]
+	if stored == nil {
+		return ErrNotFound
+	}
+
+	if g, ok := i.(groupedCountItem); ok {
+		if name, value := g.countGroup(); attributeString(stored, name) != value {
+			return errConflict
+		}
+	}
+
+	return nil
+}
+
+// checkPuts returns ErrAlreadyExists if any of the items written alongside another
+// write already exist. The mutex must be held by the caller.
+func (m *memoryTable) checkPuts(puts []item) error {
+	for _, i := range puts {
+		if m.items[i.pk()][i.sk()] != nil {
+			return ErrAlreadyExists
+		}
+	}
+
+	return nil
+}
+
+// setPuts stores the items written alongside another write. The mutex must be held by
+// the caller.
+func (m *memoryTable) setPuts(puts []item, putValues []map[string]types.AttributeValue) {
+	for index, i := range puts {
+		m.setItem(i.pk(), i.sk(), putValues[index])
+	}
+}
+
+// marshalPuts validates and marshals the items written alongside another write.
+func marshalPuts(puts []item) ([]map[string]types.AttributeValue, error) {
+	putValues := make([]map[string]types.AttributeValue, 0, len(puts))
+	for _, i := range puts {
+		if err := i.validate(); err != nil {
+			return nil, fmt.Errorf("failed to validate %s: %w", i.itemType(), err)
+		}
+
+		attributeValues, err := marshalItem(i)
+		if err != nil {
+			return nil, err
+		}
+		putValues = append(putValues, attributeValues)
+	}
+
+	return putValues, nil
+}
+
+// setCount sets the count attribute of the count item with the given keys.
+func (m *memoryTable) setCount(ctx context.Context, pk string, sk string, count int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.setItem(pk, sk, countAttributes(pk, sk, count))
+
+	return nil
+}
+
+// adjustCounts adds each delta to its count item.
+func (m *memoryTable) adjustCounts(ctx context.Context, deltas map[countKey]int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.addCounts(deltas)
+
+	return nil
+}
+
+// addCounts adds each delta to the count attribute of its count item, creating the
+// count items that don't exist. The mutex must be held by the caller.
+func (m *memoryTable) addCounts(deltas map[countKey]int64) {
+	for k, delta := range deltas {
+		var count int64
+		if n, ok := m.items[k.pk][k.sk]["count"].(*types.AttributeValueMemberN); ok {
+			count, _ = strconv.ParseInt(n.Value, 10, 64)
+		}
+
+		m.setItem(k.pk, k.sk, countAttributes(k.pk, k.sk, count+delta))
+	}
+}
+
+// countAttributes returns the attribute values of a count item.
+func countAttributes(pk string, sk string, count int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk":    &types.AttributeValueMemberS{Value: pk},
+		"sk":    &types.AttributeValueMemberS{Value: sk},
+		"count": &types.AttributeValueMemberN{Value: strconv.FormatInt(count, 10)},
+	}
+}
+
+// setItem stores the attribute values under the given keys. The mutex must be held
+// by the caller.
+func (m *memoryTable) setItem(pk string, sk string, attributeValues map[string]types.AttributeValue) {
+	if m.items[pk] == nil {
+		m.items[pk] = map[string]map[string]types.AttributeValue{}
+	}
+	m.items[pk][sk] = attributeValues
+}
+
+// indexItems returns the items in the Gsi1 partition for the itemType in index
+// order. The mutex must be held by the caller.
+func (m *memoryTable) indexItems(it ItemType) []map[string]types.AttributeValue {
+	dbItems := []map[string]types.AttributeValue{}
+	for _, pk := range m.sortedPrimaryKeys() {
+		for _, sk := range m.sortedSortKeys(pk) {
+			dbItem := m.items[pk][sk]
+			if attributeString(dbItem, "gsiPk1") == string(it) {
+				dbItems = append(dbItems, dbItem)
+			}
+		}
+	}
+
+	// Items are already in table order so a stable sort orders items with equal index
+	// sort keys the same way DynamoDB does.
+	sort.SliceStable(dbItems, func(i, j int) bool {
+		return attributeString(dbItems[i], "gsiSk1") < attributeString(dbItems[j], "gsiSk1")
+	})
+
+	return dbItems
+}
+
+func (m *memoryTable) sortedPrimaryKeys() []string {
+	keys := make([]string, 0, len(m.items))
+	for k := range m.items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (m *memoryTable) sortedSortKeys(pk string) []string {
+	keys := make([]string, 0, len(m.items[pk]))
+	for k := range m.items[pk] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+var (
+	// tableOrder is the order of the attributes items are sorted by in the table.
+	tableOrder = []string{"pk", "sk"}
+	// indexOrder is the order of the attributes items are sorted by in Gsi1. Items with
+	// equal index sort keys are in table order.
+	indexOrder = []string{"gsiSk1", "pk", "sk"}
+)
+
+// paginate unmarshals the page of dbItems after the cursor in options into items
+// and returns the cursor of the next page. The dbItems must be sorted by the order
+// attributes. The key function returns the key attributes DynamoDB would return in the
+// LastEvaluatedKey for an item.
+func paginate(dbItems []map[string]types.AttributeValue, key func(map[string]types.AttributeValue) map[string]types.AttributeValue, order []string, options PageOptions, items interface{}) (string, error) {
+	exclusiveStartKey, err := decodeCursor(options.Cursor)
+	if err != nil {
+		return "", err
+	}
+
+	page := []map[string]types.AttributeValue{}
+	var lastEvaluatedKey map[string]types.AttributeValue
+
+	for _, dbItem := range dbItems {
+		// Like DynamoDB, resume from the first item after the exclusive start key so the
+		// rest of the items are still returned if its item was deleted between pages.
+		if exclusiveStartKey != nil && compareKeys(dbItem, exclusiveStartKey, order) <= 0 {
+			continue
+		}
+
+		if int32(len(page)) == options.limit() {
+			// Only return a cursor if there is at least one more item. DynamoDB can also
+			// return one when the limit is reached exactly, leaving an empty last page.
+			lastEvaluatedKey = key(page[len(page)-1])
+			break
+		}
+		page = append(page, dbItem)
+	}
+
+	if err := attributevalue.UnmarshalListOfMaps(page, &items); err != nil {
+		return "", fmt.Errorf("failed to unmarshal items into slice: %w", err)
+	}
+
+	return encodeCursor(lastEvaluatedKey)
+}
+
+// compareKeys compares the order attributes of two items in turn, returning -1, 0 or
+// 1 like strings.Compare.
+func compareKeys(a map[string]types.AttributeValue, b map[string]types.AttributeValue, order []string) int {
+	for _, name := range order {
+		if c := strings.Compare(attributeString(a, name), attributeString(b, name)); c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// tableKey returns the key attributes of an item as DynamoDB would return them in the
+// LastEvaluatedKey of a table scan.
+func tableKey(dbItem map[string]types.AttributeValue) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"pk": dbItem["pk"],
+		"sk": dbItem["sk"],
+	}
+}
+
+// indexKey returns the key attributes of an item as DynamoDB would return them in the
+// LastEvaluatedKey of a Gsi1 query.
+func indexKey(dbItem map[string]types.AttributeValue) map[string]types.AttributeValue {
+	key := map[string]types.AttributeValue{}
+	for _, name := range []string{"pk", "sk", "gsiPk1", "gsiSk1"} {
+		if value, ok := dbItem[name]; ok {
+			key[name] = value
+		}
+	}
+
+	return key
+}
+
+// isExpired reports whether the item is past its expiresAt attribute and would be
+// waiting to be deleted by DynamoDB's time to live.
+func isExpired(dbItem map[string]types.AttributeValue) bool {
+	n, ok := dbItem["expiresAt"].(*types.AttributeValueMemberN)
+	if !ok {
+		return false
+	}
+
+	expiresAt, err := strconv.ParseInt(n.Value, 10, 64)
+	return err == nil && expiresAt < time.Now().Unix()
+}
+
+// attributeString returns the value of a string attribute or an empty string if it
+// doesn't exist or isn't a string.
+func attributeString(attributeValues map[string]types.AttributeValue, name string) string {
+	if s, ok := attributeValues[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+
+	return ""
+}
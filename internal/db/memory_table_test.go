@@ -0,0 +1,311 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryTableCount(t *testing.T) {
+	table := newMemoryTable()
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	require.NoError(t, table.putItem(ctx, subscription, putIfNotExists))
+	require.ErrorIs(t, table.putItem(ctx, NewSubscription("other-id", "test@example.com"), putIfNotExists), ErrAlreadyExists)
+
+	var count struct {
+		Count int64 `dynamodbav:"count"`
+	}
+	require.NoError(t, table.getItem(ctx, subscription.countPK(), subscription.countSK(), &count))
+	require.Equal(t, int64(1), count.Count)
+
+	require.NoError(t, table.deleteItem(ctx, subscription))
+	require.NoError(t, table.getItem(ctx, subscription.countPK(), subscription.countSK(), &count))
+	require.Equal(t, int64(0), count.Count)
+
+	// Deleting an item that doesn't exist leaves the count alone.
+	require.ErrorIs(t, table.deleteItem(ctx, subscription), ErrNotFound)
+	require.NoError(t, table.getItem(ctx, subscription.countPK(), subscription.countSK(), &count))
+	require.Equal(t, int64(0), count.Count)
+}
+
+func TestReconcileSubscriptionCount(t *testing.T) {
+	store := NewMemoryStore()
+	table := store.table.(*memoryTable)
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+	table.addCounts(countDeltas(nil, NewSubscription("other-id", "other@example.com")))
+
+	count, err := store.ReconcileSubscriptionCount(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	pending, err := store.GetSubscriptionCount(ctx, SubscriptionStatusPending)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), pending)
+}
+
+func TestSubscriptionCounts(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+	require.NoError(t, store.CreateSubscription(ctx, NewSubscription("other-id", "other@example.com")))
+
+	subscription.Confirm()
+	require.NoError(t, store.UpdateSubscription(ctx, subscription))
+
+	requireCounts := func(total int64, pending int64, confirmed int64) {
+		count, err := store.GetCount(ctx, ItemTypeSubscription)
+		require.NoError(t, err)
+		require.Equal(t, total, count)
+
+		count, err = store.GetSubscriptionCount(ctx, SubscriptionStatusPending)
+		require.NoError(t, err)
+		require.Equal(t, pending, count)
+
+		count, err = store.GetSubscriptionCount(ctx, SubscriptionStatusConfirmed)
+		require.NoError(t, err)
+		require.Equal(t, confirmed, count)
+	}
+	requireCounts(2, 1, 1)
+
+	require.NoError(t, store.DeleteSubscription(ctx, subscription.EmailAddress, subscription.ID))
+	requireCounts(1, 1, 0)
+
+	require.ErrorIs(t, store.UpdateSubscription(ctx, subscription), ErrNotFound)
+	requireCounts(1, 1, 0)
+}
+
+func TestMemoryTableUpdate(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+	createdAt := subscription.CreatedAt
+
+	// Only the attributes in the update expression are updated.
+	subscription.Confirm()
+	subscription.CreatedAt = createdAt.Add(time.Hour)
+	require.NoError(t, store.UpdateSubscription(ctx, subscription))
+
+	stored, err := store.GetSubscription(ctx, subscription.EmailAddress)
+	require.NoError(t, err)
+	require.Equal(t, SubscriptionStatusConfirmed, stored.Status)
+	require.Zero(t, stored.ExpiresAt)
+	require.True(t, createdAt.Equal(stored.CreatedAt))
+}
+
+func TestMemoryTablePagination(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		require.NoError(t, store.CreateSubscription(ctx, NewSubscription(id, id+"@example.com")))
+	}
+
+	ids := []string{}
+	options := PageOptions{Limit: 2}
+	for {
+		page, err := store.GetSubscriptionsPage(ctx, options)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Subscriptions), 2)
+
+		for _, s := range page.Subscriptions {
+			ids = append(ids, s.ID)
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	require.Equal(t, []string{"1", "2", "3", "4", "5"}, ids)
+
+	subscriptions, errs := store.StreamSubscriptions(ctx, 2)
+	count := 0
+	for range subscriptions {
+		count++
+	}
+	require.NoError(t, <-errs)
+	require.Equal(t, 5, count)
+
+	_, err := store.GetSubscriptionsPage(ctx, PageOptions{Cursor: "not a cursor"})
+	require.ErrorIs(t, err, ErrInvalidCursor)
+
+	// Deleting the cursor's item between pages doesn't truncate the listing.
+	page, err := store.GetSubscriptionsPage(ctx, PageOptions{Limit: 2})
+	require.NoError(t, err)
+	require.NoError(t, store.DeleteSubscription(ctx, "2@example.com", "2"))
+	page, err = store.GetSubscriptionsPage(ctx, PageOptions{Cursor: page.NextCursor})
+	require.NoError(t, err)
+	ids = []string{}
+	for _, s := range page.Subscriptions {
+		ids = append(ids, s.ID)
+	}
+	require.Equal(t, []string{"3", "4", "5"}, ids)
+}
+
+func TestBackfillSubscriptionIndex(t *testing.T) {
+	store := NewMemoryStore()
+	table := store.table.(*memoryTable)
+	ctx := context.Background()
+
+	// Simulate subscriptions written before the index keys and status were maintained,
+	// when only whether they were confirmed was recorded.
+	confirmed := NewSubscription("confirmed-id", "confirmed@example.com")
+	pending := NewSubscription("pending-id", "pending@example.com")
+	missingCreatedAt := NewSubscription("missing-created-at-id", "missing-created-at@example.com")
+	for _, subscription := range []*Subscription{confirmed, pending, missingCreatedAt} {
+		require.NoError(t, store.CreateSubscription(ctx, subscription))
+		attributeValues := table.items[subscription.pk()][subscription.sk()]
+		for _, name := range []string{"gsiPk1", "gsiSk1", "status", "expiresAt"} {
+			delete(attributeValues, name)
+		}
+		attributeValues["isConfirmed"] = &types.AttributeValueMemberBOOL{Value: subscription == confirmed}
+	}
+	delete(table.items[missingCreatedAt.pk()][missingCreatedAt.sk()], "createdAt")
+
+	subscriptions, err := store.GetSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 0)
+
+	result, err := store.BackfillSubscriptionIndex(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Updated)
+	require.Len(t, result.Skipped, 1)
+	require.Equal(t, missingCreatedAt.ID, result.Skipped[0].ID)
+
+	subscriptions, err = store.GetSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 2)
+	statuses := map[string]SubscriptionStatus{}
+	for _, subscription := range subscriptions {
+		statuses[subscription.ID] = subscription.Status
+	}
+	require.Equal(t, map[string]SubscriptionStatus{
+		confirmed.ID: SubscriptionStatusConfirmed,
+		pending.ID:   SubscriptionStatusPending,
+	}, statuses)
+}
+
+func TestDeliveries(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	require.NoError(t, store.CreateDelivery(ctx, NewDelivery("broadcast", subscription)))
+	require.ErrorIs(t, store.CreateDelivery(ctx, NewDelivery("broadcast", subscription)), ErrAlreadyExists)
+	require.NoError(t, store.CreateDelivery(ctx, NewDelivery("other-broadcast", subscription)))
+
+	count, err := store.GetDeliveryCount(ctx, "broadcast")
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	require.NoError(t, store.DeleteDelivery(ctx, "broadcast", subscription.ID))
+	require.ErrorIs(t, store.DeleteDelivery(ctx, "broadcast", subscription.ID), ErrNotFound)
+	require.NoError(t, store.CreateDelivery(ctx, NewDelivery("broadcast", subscription)))
+}
+
+func TestExpireSubscription(t *testing.T) {
+	store := NewMemoryStore()
+	table := store.table.(*memoryTable)
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+
+	// Delete the subscription the way DynamoDB's time to live would.
+	delete(table.items[subscription.pk()], subscription.sk())
+	require.NoError(t, store.ExpireSubscription(ctx, subscription))
+
+	count, err := store.GetSubscriptionCount(ctx, SubscriptionStatusPending)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+
+	count, err = store.GetCount(ctx, ItemTypeSubscription)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+}
+
+func TestExpiredMarker(t *testing.T) {
+	store := NewMemoryStore()
+	table := store.table.(*memoryTable)
+	ctx := context.Background()
+
+	subscription := NewSubscription("id", "test@example.com")
+	subscription.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+
+	// DynamoDB's time to live deleted the subscription but not yet its marker.
+	delete(table.items[subscription.pk()], subscription.sk())
+	require.NoError(t, store.CreateSubscription(ctx, NewSubscription("other-id", "test@example.com")))
+
+	// A marker that hasn't expired still stops a second subscription being created.
+	require.ErrorIs(t, store.CreateSubscription(ctx, NewSubscription("third-id", "test@example.com")), ErrAlreadyExists)
+}
+
+func TestClaimEmail(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	claimed, err := store.ClaimEmail(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	claimed, err = store.ClaimEmail(ctx, "key")
+	require.NoError(t, err)
+	require.False(t, claimed)
+
+	require.NoError(t, store.ReleaseEmail(ctx, "key"))
+	require.NoError(t, store.ReleaseEmail(ctx, "key"))
+
+	claimed, err = store.ClaimEmail(ctx, "key")
+	require.NoError(t, err)
+	require.True(t, claimed)
+}
+
+func TestOutbox(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := context.Background()
+
+	subscription := NewSubscription("subscription-id", "test@example.com")
+	confirmation := NewOutboxEntry("confirmation-id", OutboxKindSubscriptionConfirmation, subscription)
+	require.NoError(t, store.CreateSubscription(ctx, subscription, confirmation))
+
+	// Nothing is written if an entry already exists.
+	subscription.Confirm()
+	require.ErrorIs(t, store.UpdateSubscription(ctx, subscription, confirmation), ErrAlreadyExists)
+	stored, err := store.GetSubscription(ctx, subscription.EmailAddress)
+	require.NoError(t, err)
+	require.Equal(t, SubscriptionStatusPending, stored.Status)
+
+	welcome := NewOutboxEntry("welcome-id", OutboxKindWelcome, subscription)
+	require.NoError(t, store.UpdateSubscription(ctx, subscription, welcome))
+
+	entries, err := store.GetPendingOutboxEntries(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, "confirmation-id", entries[0].ID)
+
+	require.NoError(t, store.PublishOutboxEntry(ctx, entries[0]))
+	require.NoError(t, store.PublishOutboxEntry(ctx, entries[0]))
+	require.ErrorIs(t, store.PublishOutboxEntry(ctx, &OutboxEntry{ID: "missing-id", Kind: OutboxKindWelcome, EmailAddress: "test@example.com"}), ErrNotFound)
+
+	entries, err = store.GetPendingOutboxEntries(ctx)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, "welcome-id", entries[0].ID)
+
+	count, err := store.GetCount(ctx, ItemTypeOutbox)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+}
@@ -0,0 +1,204 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// OutboxKind is the email an outbox entry publishes.
+type OutboxKind string
+
+const (
+	// OutboxKindSubscriptionConfirmation asks a reader to confirm their new subscription.
+	OutboxKindSubscriptionConfirmation OutboxKind = "SUBSCRIPTION_CONFIRMATION"
+	// OutboxKindWelcome welcomes a reader who confirmed their subscription.
+	OutboxKindWelcome OutboxKind = "WELCOME"
+	// OutboxKindGoodbye tells a confirmed reader they have been unsubscribed.
+	OutboxKindGoodbye OutboxKind = "GOODBYE"
+)
+
+// OutboxStatus is the state of an outbox entry.
+type OutboxStatus string
+
+const (
+	// OutboxStatusPending is the status of an entry that hasn't been published yet.
+	OutboxStatusPending OutboxStatus = "PENDING"
+	// OutboxStatusPublished is the status of an entry whose email has been handed to the
+	// notification backend. Published entries expire after PublishedOutboxEntryTTL.
+	OutboxStatusPublished OutboxStatus = "PUBLISHED"
+)
+
+// PublishedOutboxEntryTTL is how long a published outbox entry is kept before it is
+// expired by DynamoDB's time to live.
+const PublishedOutboxEntryTTL = 7 * 24 * time.Hour
+
+// OutboxEntry is an email to send because of a change to a subscription. Entries are
+// written in the same transaction as the change, by passing them to the
+// SubscriptionStore's write methods, so the email is never lost or sent for a change
+// that didn't happen. A relay publishes pending entries and marks them published.
+type OutboxEntry struct {
+	ID             string       `json:"id" dynamodbav:"id"`
+	Kind           OutboxKind   `json:"kind" dynamodbav:"kind"`
+	Status         OutboxStatus `json:"status" dynamodbav:"status"`
+	SubscriptionID string       `json:"subscriptionId" dynamodbav:"subscriptionId"`
+	EmailAddress   string       `json:"emailAddress" dynamodbav:"emailAddress"`
+	CreatedAt      time.Time    `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is the Unix time in seconds at which DynamoDB will delete the entry. It
+	// is only set once the entry is published.
+	ExpiresAt int64 `json:"expiresAt,omitempty" dynamodbav:"expiresAt,omitempty"`
+}
+
+// NewOutboxEntry returns a pending outbox entry of the given kind for the subscription.
+func NewOutboxEntry(id string, kind OutboxKind, subscription *Subscription) *OutboxEntry {
+	return &OutboxEntry{
+		ID:             id,
+		Kind:           kind,
+		Status:         OutboxStatusPending,
+		SubscriptionID: subscription.ID,
+		EmailAddress:   subscription.EmailAddress,
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+// GetPendingOutboxEntries fetches every outbox entry that hasn't been published,
+// oldest first.
+func (s *Store) GetPendingOutboxEntries(ctx context.Context) ([]*OutboxEntry, error) {
+	entries := []*OutboxEntry{}
+	options := PageOptions{}
+
+	for {
+		page := []*OutboxEntry{}
+		cursor, err := s.table.getItems(ctx, ItemTypeOutbox, options, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get pending outbox entries: %w", err)
+		}
+		entries = append(entries, page...)
+
+		if cursor == "" {
+			return entries, nil
+		}
+		options.Cursor = cursor
+	}
+}
+
+// PublishOutboxEntry marks a pending outbox entry as published. Entries that have
+// already been published are left as they are so the relay can be retried. If the
+// entry doesn't exist ErrNotFound is returned.
+func (s *Store) PublishOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	pending := *entry
+	pending.Status = OutboxStatusPending
+
+	published := *entry
+	published.Status = OutboxStatusPublished
+	published.ExpiresAt = time.Now().Add(PublishedOutboxEntryTTL).Unix()
+
+	err := s.table.updateItem(ctx, &published, &pending)
+	if errors.Is(err, errConflict) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to publish outbox entry: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireOutboxEntry decrements the count items of a published outbox entry that
+// DynamoDB deleted when it expired. The entry must be the item as it was deleted so
+// its status count is decremented.
+func (s *Store) ExpireOutboxEntry(ctx context.Context, entry *OutboxEntry) error {
+	if err := s.table.adjustCounts(ctx, countDeltas(entry, nil)); err != nil {
+		return fmt.Errorf("failed to decrement expired outbox entry counts: %w", err)
+	}
+
+	return nil
+}
+
+// outboxItems converts outbox entries into the items written alongside a subscription.
+func outboxItems(entries []*OutboxEntry) []item {
+	items := make([]item, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry)
+	}
+
+	return items
+}
+
+func (e *OutboxEntry) pk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeOutbox, e.ID)
+}
+
+func (e *OutboxEntry) sk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeOutbox, e.ID)
+}
+
+func (e *OutboxEntry) countPK() string {
+	return string(ItemTypeCount)
+}
+
+func (e *OutboxEntry) countSK() string {
+	return fmt.Sprintf("%s#%s", ItemTypeCount, e.itemType())
+}
+
+// countGroup counts entries per status so the number of pending entries can be
+// monitored. Published entries expired by DynamoDB are decremented by the stream
+// lambda.
+func (e *OutboxEntry) countGroup() (string, string) {
+	return "status", string(e.Status)
+}
+
+// gsiPK1 moves published entries out of the item type's index partition so listing the
+// pending entries doesn't read every entry that has been published.
+func (e *OutboxEntry) gsiPK1() string {
+	if e.Status == OutboxStatusPublished {
+		return fmt.Sprintf("%s#%s", ItemTypeOutbox, OutboxStatusPublished)
+	}
+
+	return string(ItemTypeOutbox)
+}
+
+func (e *OutboxEntry) gsiSK1() string {
+	return fmt.Sprintf("%s#%s", e.CreatedAt.UTC().Format(sortableTimeFormat), e.ID)
+}
+
+func (e *OutboxEntry) itemType() ItemType {
+	return ItemTypeOutbox
+}
+
+func (e *OutboxEntry) update() expression.UpdateBuilder {
+	update := expression.Set(expression.Name("status"), expression.Value(e.Status))
+	if e.ExpiresAt == 0 {
+		update = update.Remove(expression.Name("expiresAt"))
+	} else {
+		update = update.Set(expression.Name("expiresAt"), expression.Value(e.ExpiresAt))
+	}
+
+	return update
+}
+
+func (e *OutboxEntry) validate() error {
+	if len(e.ID) == 0 {
+		return errors.New("id cannot be empty")
+	}
+	if len(e.EmailAddress) == 0 {
+		return errors.New("email address cannot be empty")
+	}
+
+	switch e.Kind {
+	case OutboxKindSubscriptionConfirmation, OutboxKindWelcome, OutboxKindGoodbye:
+	default:
+		return fmt.Errorf("invalid kind: %q", e.Kind)
+	}
+
+	switch e.Status {
+	case OutboxStatusPending, OutboxStatusPublished:
+	default:
+		return fmt.Errorf("invalid status: %q", e.Status)
+	}
+
+	return nil
+}
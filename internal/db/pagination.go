@@ -0,0 +1,81 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DefaultPageSize is the page size used when PageOptions.Limit is zero.
+const DefaultPageSize int32 = 100
+
+// ErrInvalidCursor is returned when a cursor can't be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageOptions controls which page of items is fetched.
+type PageOptions struct {
+	// Limit is the maximum number of items in the page. If zero, DefaultPageSize is used.
+	Limit int32
+	// Cursor is the NextCursor of the previous page. If empty, the first page is fetched.
+	Cursor string
+}
+
+func (p PageOptions) limit() int32 {
+	if p.Limit <= 0 {
+		return DefaultPageSize
+	}
+
+	return p.Limit
+}
+
+// encodeCursor encodes the key of the last evaluated item into an opaque cursor. An
+// empty cursor is returned if the key is empty, meaning there are no more pages.
+func encodeCursor(lastEvaluatedKey map[string]types.AttributeValue) (string, error) {
+	if len(lastEvaluatedKey) == 0 {
+		return "", nil
+	}
+
+	key := map[string]string{}
+	for name, value := range lastEvaluatedKey {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("unexpected non-string key attribute: %s", name)
+		}
+		key[name] = s.Value
+	}
+
+	data, err := json.Marshal(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cursor: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor decodes a cursor created by encodeCursor back into an exclusive start
+// key. A nil key is returned for an empty cursor.
+func decodeCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if len(cursor) == 0 {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	key := map[string]string{}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	exclusiveStartKey := map[string]types.AttributeValue{}
+	for name, value := range key {
+		exclusiveStartKey[name] = &types.AttributeValueMemberS{Value: value}
+	}
+
+	return exclusiveStartKey, nil
+}
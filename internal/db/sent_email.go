@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+)
+
+// SentEmailTTL is how long the ledger remembers an email was sent. Retries happen well
+// within it and it outlives the pending subscriptions whose confirmation emails are
+// the most likely to be resent.
+const SentEmailTTL = 30 * 24 * time.Hour
+
+// SentEmail records the idempotency key of an email in the ledger before it's sent so
+// it isn't sent again when a send is retried.
+type SentEmail struct {
+	Key       string    `json:"key" dynamodbav:"key"`
+	CreatedAt time.Time `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is the Unix time in seconds at which DynamoDB will delete the record.
+	ExpiresAt int64 `json:"expiresAt" dynamodbav:"expiresAt"`
+}
+
+// ClaimEmail records the idempotency key of an email that is about to be sent. It
+// reports false if the key has already been claimed, in which case the email
+// shouldn't be sent.
+func (s *Store) ClaimEmail(ctx context.Context, key string) (bool, error) {
+	now := time.Now().UTC()
+
+	err := s.table.putItem(ctx, &SentEmail{
+		Key:       key,
+		CreatedAt: now,
+		ExpiresAt: now.Add(SentEmailTTL).Unix(),
+	}, putIfNotExists)
+	if errors.Is(err, ErrAlreadyExists) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim email: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReleaseEmail deletes a claimed idempotency key so the email is sent when it's
+// retried. It should be called if the email couldn't be sent.
+func (s *Store) ReleaseEmail(ctx context.Context, key string) error {
+	err := s.table.deleteItem(ctx, &SentEmail{Key: key})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to release email: %w", err)
+	}
+
+	return nil
+}
+
+// ExpireSentEmail decrements the count item of a ledger record that DynamoDB deleted
+// when it expired.
+func (s *Store) ExpireSentEmail(ctx context.Context, sentEmail *SentEmail) error {
+	if err := s.table.adjustCounts(ctx, countDeltas(sentEmail, nil)); err != nil {
+		return fmt.Errorf("failed to decrement expired sent email count: %w", err)
+	}
+
+	return nil
+}
+
+func (e *SentEmail) pk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeSentEmail, e.Key)
+}
+
+func (e *SentEmail) sk() string {
+	return fmt.Sprintf("%s#%s", ItemTypeSentEmail, e.Key)
+}
+
+func (e *SentEmail) countPK() string {
+	return string(ItemTypeCount)
+}
+
+func (e *SentEmail) countSK() string {
+	return fmt.Sprintf("%s#%s", ItemTypeCount, e.itemType())
+}
+
+func (e *SentEmail) gsiPK1() string {
+	return string(ItemTypeSentEmail)
+}
+
+func (e *SentEmail) gsiSK1() string {
+	return fmt.Sprintf("%s#%s", e.CreatedAt.UTC().Format(sortableTimeFormat), e.Key)
+}
+
+func (e *SentEmail) itemType() ItemType {
+	return ItemTypeSentEmail
+}
+
+// update only sets the expiry as the other attributes of a record are part of its key.
+func (e *SentEmail) update() expression.UpdateBuilder {
+	return expression.Set(expression.Name("expiresAt"), expression.Value(e.ExpiresAt))
+}
+
+func (e *SentEmail) validate() error {
+	if len(e.Key) == 0 {
+		return errors.New("key cannot be empty")
+	}
+
+	return nil
+}
@@ -4,28 +4,108 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 )
 
+// SubscriptionStatus is the lifecycle state of a subscription.
+type SubscriptionStatus string
+
+const (
+	// SubscriptionStatusPending is the status of a subscription that has been created but
+	// not yet confirmed by the reader. Pending subscriptions expire after PendingSubscriptionTTL.
+	SubscriptionStatusPending SubscriptionStatus = "PENDING"
+	// SubscriptionStatusConfirmed is the status of a subscription the reader has confirmed.
+	SubscriptionStatusConfirmed SubscriptionStatus = "CONFIRMED"
+	// SubscriptionStatusHeld is the status of a subscription whose captcha score was too
+	// low to accept but not low enough to reject. It waits for the site owner to approve
+	// or reject it and expires after HeldSubscriptionTTL.
+	SubscriptionStatusHeld SubscriptionStatus = "HELD"
+	// SubscriptionStatusUnsubscribed is the terminal status of a subscription. Unsubscribed
+	// subscriptions are removed from the table so this status is never persisted.
+	SubscriptionStatusUnsubscribed SubscriptionStatus = "UNSUBSCRIBED"
+)
+
+// PendingSubscriptionTTL is how long a subscription can remain pending before it
+// is expired by DynamoDB's time to live.
+const PendingSubscriptionTTL = 7 * 24 * time.Hour
+
+// HeldSubscriptionTTL is how long a subscription can be held for review before it is
+// expired by DynamoDB's time to live.
+const HeldSubscriptionTTL = 30 * 24 * time.Hour
+
 type Subscription struct {
-	EmailAddress string `json:"emailAddress" dynamodbav:"emailAddress"`
-	ID           string `json:"id" dynamodbav:"id"`
-	IsConfirmed  bool   `json:"isConfirmed" dynamodbav:"isConfirmed"`
+	EmailAddress string             `json:"emailAddress" dynamodbav:"emailAddress"`
+	ID           string             `json:"id" dynamodbav:"id"`
+	Status       SubscriptionStatus `json:"status" dynamodbav:"status"`
+	CreatedAt    time.Time          `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is the Unix time in seconds at which DynamoDB will delete the subscription.
+	// It is only set while the subscription is pending.
+	ExpiresAt int64 `json:"expiresAt,omitempty" dynamodbav:"expiresAt,omitempty"`
+}
+
+// NewSubscription returns a pending subscription that expires after PendingSubscriptionTTL.
+func NewSubscription(id string, emailAddress string) *Subscription {
+	now := time.Now().UTC()
+
+	return &Subscription{
+		EmailAddress: emailAddress,
+		ID:           id,
+		Status:       SubscriptionStatusPending,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(PendingSubscriptionTTL).Unix(),
+	}
 }
 
-// Create creates a new subscription.
-func (s *Subscription) Create(ctx context.Context) error {
-	if err := putItem(ctx, s); err != nil {
+// CreateSubscription creates a new subscription and the outbox entries in a single
+// transaction. If a subscription with the same email address already exists
+// ErrAlreadyExists is returned.
+func (s *Store) CreateSubscription(ctx context.Context, subscription *Subscription, outbox ...*OutboxEntry) error {
+	if err := s.table.putItem(ctx, subscription, putIfNotExists, outboxItems(outbox)...); err != nil {
 		return fmt.Errorf("failed to create subscription: %w", err)
 	}
 
 	return nil
 }
 
-// DeleteSubscription deletes a subscription via its email address and ID.
-func DeleteSubscription(ctx context.Context, emailAddress, id string) error {
-	if err := deleteItem(ctx, itemTypeSubscription, fmt.Sprintf("%s#%s", itemTypeSubscription, emailAddress), fmt.Sprintf("%s#%s", itemTypeSubscription, id)); err != nil {
+// Confirm marks the subscription as confirmed and clears its expiry. The change
+// isn't persisted until the subscription is updated.
+func (s *Subscription) Confirm() {
+	s.Status = SubscriptionStatusConfirmed
+	s.ExpiresAt = 0
+}
+
+// Hold marks a new subscription as held for review and extends its expiry to
+// HeldSubscriptionTTL. The change isn't persisted until the subscription is created.
+func (s *Subscription) Hold() {
+	s.Status = SubscriptionStatusHeld
+	s.ExpiresAt = time.Now().Add(HeldSubscriptionTTL).Unix()
+}
+
+// Approve moves a held subscription to pending so the reader can confirm it, resetting
+// its expiry to PendingSubscriptionTTL. The change isn't persisted until the
+// subscription is updated.
+func (s *Subscription) Approve() {
+	s.Status = SubscriptionStatusPending
+	s.ExpiresAt = time.Now().Add(PendingSubscriptionTTL).Unix()
+}
+
+// IsExpired reports whether the subscription has passed its expiry time. DynamoDB
+// can take a while to delete expired items so this should be checked on reads.
+func (s *Subscription) IsExpired() bool {
+	return s.ExpiresAt != 0 && time.Now().Unix() >= s.ExpiresAt
+}
+
+// DeleteSubscription deletes a subscription via its email address and ID and creates
+// the outbox entries in a single transaction. If the subscription doesn't exist
+// ErrNotFound is returned.
+func (s *Store) DeleteSubscription(ctx context.Context, emailAddress string, id string, outbox ...*OutboxEntry) error {
+	// The stored subscription is needed to know which status count to decrement.
+	err := s.retryOnConflict(ctx, &Subscription{EmailAddress: emailAddress, ID: id}, func(stored *Subscription) error {
+		return s.table.deleteItem(ctx, stored, outboxItems(outbox)...)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to delete subscription: %w", err)
 	}
 
@@ -33,66 +113,256 @@ func DeleteSubscription(ctx context.Context, emailAddress, id string) error {
 }
 
 // GetSubscription fetches a subscription via its email address.
-func GetSubscription(ctx context.Context, emailAddress string) (*Subscription, error) {
+func (s *Store) GetSubscription(ctx context.Context, emailAddress string) (*Subscription, error) {
 	var subscription *Subscription
-	if err := getItem(ctx, fmt.Sprintf("%s#%s", itemTypeSubscription, emailAddress), "", &subscription); err != nil {
+	if err := s.table.getItem(ctx, fmt.Sprintf("%s#%s", ItemTypeSubscription, emailAddress), "", &subscription); err != nil {
 		return nil, fmt.Errorf("failed to get subscription: %w", err)
 	}
 
 	return subscription, nil
 }
 
-// GetSubscriptions fetches a slice of subscriptions.
-func GetSubscriptions(ctx context.Context) ([]*Subscription, error) {
+// GetSubscriptions fetches every subscription, walking all pages of the index.
+func (s *Store) GetSubscriptions(ctx context.Context) ([]*Subscription, error) {
 	subscriptions := []*Subscription{}
-	if err := getItems(ctx, itemTypeSubscription, &subscriptions); err != nil {
-		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	options := PageOptions{}
+
+	for {
+		page, err := s.GetSubscriptionsPage(ctx, options)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, page.Subscriptions...)
+
+		if page.NextCursor == "" {
+			return subscriptions, nil
+		}
+		options.Cursor = page.NextCursor
+	}
+}
+
+// SubscriptionPage is a single page of subscriptions.
+type SubscriptionPage struct {
+	Subscriptions []*Subscription `json:"subscriptions"`
+	// NextCursor is the cursor to pass to fetch the next page. It is empty if there are
+	// no more pages.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// GetSubscriptionsPage fetches a single page of subscriptions.
+func (s *Store) GetSubscriptionsPage(ctx context.Context, options PageOptions) (*SubscriptionPage, error) {
+	page := &SubscriptionPage{
+		Subscriptions: []*Subscription{},
+	}
+
+	var err error
+	page.NextCursor, err = s.table.getItems(ctx, ItemTypeSubscription, options, &page.Subscriptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscriptions: %w", err)
 	}
 
-	return subscriptions, nil
+	return page, nil
+}
+
+// StreamSubscriptions sends every subscription on the returned subscription channel,
+// fetching pages of pageSize as they are consumed. Both channels are closed once all
+// pages have been sent or an error occurs, in which case the error is sent on the
+// error channel first. Callers should drain the subscription channel and then check
+// the error channel.
+func (s *Store) StreamSubscriptions(ctx context.Context, pageSize int32) (<-chan *Subscription, <-chan error) {
+	subscriptions := make(chan *Subscription)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(subscriptions)
+
+		options := PageOptions{
+			Limit: pageSize,
+		}
+
+		for {
+			page, err := s.GetSubscriptionsPage(ctx, options)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			for _, subscription := range page.Subscriptions {
+				select {
+				case subscriptions <- subscription:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					return
+				}
+			}
+
+			if page.NextCursor == "" {
+				return
+			}
+			options.Cursor = page.NextCursor
+		}
+	}()
+
+	return subscriptions, errs
+}
+
+// BackfillResult is the outcome of BackfillSubscriptionIndex.
+type BackfillResult struct {
+	// Updated is the number of subscriptions that were rewritten.
+	Updated int
+	// Skipped are the subscriptions without a creation time. They aren't rewritten as
+	// the index sorts subscriptions by it, so they need to be fixed by hand.
+	Skipped []*Subscription
+}
+
+// legacySubscription is a subscription that may have been written before the status
+// lifecycle was introduced, when it was only recorded whether it was confirmed.
+type legacySubscription struct {
+	Subscription
+	IsConfirmed bool `dynamodbav:"isConfirmed"`
+}
+
+// BackfillSubscriptionIndex rewrites every subscription so its Gsi1 keys are set.
+// Subscriptions written before the keys were maintained on write are missing from
+// the index and won't be returned by GetSubscriptions until this has been run. It is
+// safe to run more than once.
+func (s *Store) BackfillSubscriptionIndex(ctx context.Context) (*BackfillResult, error) {
+	result := &BackfillResult{}
+	options := PageOptions{}
+
+	for {
+		subscriptions := []*legacySubscription{}
+		cursor, err := s.table.scanItems(ctx, ItemTypeSubscription, options, &subscriptions)
+		if err != nil {
+			return result, fmt.Errorf("failed to scan subscriptions: %w", err)
+		}
+
+		for _, legacy := range subscriptions {
+			subscription := &legacy.Subscription
+			if subscription.CreatedAt.IsZero() {
+				result.Skipped = append(result.Skipped, subscription)
+				continue
+			}
+
+			// Subscriptions written before the status lifecycle was introduced only
+			// recorded whether they were confirmed.
+			if subscription.Status == "" {
+				subscription.Status = SubscriptionStatusPending
+				if legacy.IsConfirmed {
+					subscription.Status = SubscriptionStatusConfirmed
+				}
+			}
+
+			if err := s.UpdateSubscription(ctx, subscription); err != nil {
+				return result, err
+			}
+			result.Updated++
+		}
+
+		if cursor == "" {
+			return result, nil
+		}
+		options.Cursor = cursor
+	}
 }
 
-// Update updates an existing subscription.
-func (s *Subscription) Update(ctx context.Context) error {
-	if err := updateItem(ctx, s); err != nil {
+// UpdateSubscription updates an existing subscription and creates the outbox entries
+// in a single transaction. If the subscription doesn't exist ErrNotFound is returned.
+func (s *Store) UpdateSubscription(ctx context.Context, subscription *Subscription, outbox ...*OutboxEntry) error {
+	// The stored subscription is needed to move the status counts if the status changed.
+	err := s.retryOnConflict(ctx, subscription, func(stored *Subscription) error {
+		return s.table.updateItem(ctx, subscription, stored, outboxItems(outbox)...)
+	})
+	if err != nil {
 		return fmt.Errorf("failed to update subscription: %w", err)
 	}
 
 	return nil
 }
 
+// retryOnConflict reads the stored copy of the subscription and passes it to write,
+// retrying if write returns errConflict because the stored copy changed in between.
+func (s *Store) retryOnConflict(ctx context.Context, subscription *Subscription, write func(stored *Subscription) error) error {
+	for attempt := 0; ; attempt++ {
+		var stored *Subscription
+		if err := s.table.getItem(ctx, subscription.pk(), subscription.sk(), &stored); err != nil {
+			return err
+		}
+		if stored == nil {
+			return ErrNotFound
+		}
+
+		err := write(stored)
+		if !errors.Is(err, errConflict) || attempt == maxConflictRetries {
+			return err
+		}
+	}
+}
+
 func (s *Subscription) pk() string {
-	return fmt.Sprintf("%s#%s", itemTypeSubscription, s.EmailAddress)
+	return fmt.Sprintf("%s#%s", ItemTypeSubscription, s.EmailAddress)
 }
 
 func (s *Subscription) sk() string {
-	return fmt.Sprintf("%s#%s", itemTypeSubscription, s.ID)
+	return fmt.Sprintf("%s#%s", ItemTypeSubscription, s.ID)
 }
 
 func (s *Subscription) countPK() string {
-	return string(itemTypeCount)
+	return string(ItemTypeCount)
 }
 
 func (s *Subscription) countSK() string {
-	return fmt.Sprintf("%s#%s", itemTypeCount, s.itemType())
+	return fmt.Sprintf("%s#%s", ItemTypeCount, s.itemType())
+}
+
+// countGroup counts subscriptions per status as well as in total.
+func (s *Subscription) countGroup() (string, string) {
+	return "status", string(s.Status)
+}
+
+func (s *Subscription) gsiPK1() string {
+	return string(ItemTypeSubscription)
+}
+
+// gsiSK1 sorts subscriptions by creation time. The ID is appended so subscriptions
+// created at the same time have unique sort keys.
+func (s *Subscription) gsiSK1() string {
+	return fmt.Sprintf("%s#%s", s.CreatedAt.UTC().Format(sortableTimeFormat), s.ID)
+}
+
+// uniqueKey makes subscriptions unique on their email address. The marker is kept
+// in its own partition so it isn't returned when querying a subscription's partition.
+func (s *Subscription) uniqueKey() (string, string) {
+	key := fmt.Sprintf("%s#%s#%s", ItemTypeUnique, ItemTypeSubscription, s.EmailAddress)
+	return key, key
 }
 
-func (s *Subscription) itemType() itemType {
-	return itemTypeSubscription
+func (s *Subscription) itemType() ItemType {
+	return ItemTypeSubscription
 }
 
-func (s *Subscription) updateExpression() (expression.Expression, error) {
-	return expression.NewBuilder().WithUpdate(
-		expression.Set(
-			expression.Name("isConfirmed"),
-			expression.Value(s.IsConfirmed),
-		),
-	).Build()
+func (s *Subscription) update() expression.UpdateBuilder {
+	update := expression.Set(expression.Name("status"), expression.Value(s.Status))
+	if s.ExpiresAt == 0 {
+		update = update.Remove(expression.Name("expiresAt"))
+	} else {
+		update = update.Set(expression.Name("expiresAt"), expression.Value(s.ExpiresAt))
+	}
+
+	return update
 }
 
 func (s *Subscription) validate() error {
 	if len(s.EmailAddress) == 0 {
 		return errors.New("email address cannot be empty")
 	}
+
+	switch s.Status {
+	case SubscriptionStatusPending, SubscriptionStatusConfirmed, SubscriptionStatusHeld:
+	default:
+		return fmt.Errorf("invalid status: %q", s.Status)
+	}
+
 	return nil
 }
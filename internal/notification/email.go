@@ -2,46 +2,98 @@ package notification
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	email "github.com/gofor-little/aws-email"
-
-	"github.com/strongishllama/millhouse.dev-cdk/internal/tmpl"
+	"github.com/gofor-little/log"
 )
 
+// EnqueueEmail renders the template, inlines the shared stylesheet into it and hands
+// the email to EmailSender, returning the ID of the message it was sent as. The
+// plain-text part is rendered from the sibling
+// .tmpl.txt template if there is one, otherwise it's converted from the HTML.
+//
+// If the template has an IdempotencyKey and EmailLedger is set, the key is claimed
+// before the email is sent and released if sending fails. An email whose key has
+// already been claimed is skipped and an empty ID is returned.
 func EnqueueEmail(ctx context.Context, to []string, from string, emailTemplate EmailTemplate) (string, error) {
 	if err := checkPackage(); err != nil {
 		return "", err
 	}
 
-	data, err := tmpl.NewTemplateFromFile(templates, "templates/"+emailTemplate.FileName, emailTemplate.Data)
+	renderedBody, err := registry.Render(emailTemplate.FileName, emailTemplate.Data)
 	if err != nil {
-		return "", fmt.Errorf("failed to create template from file: %w", err)
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	htmlBody := emailStylesheet.inline(string(renderedBody))
+
+	textBody, err := renderText(emailTemplate, htmlBody)
+	if err != nil {
+		return "", err
+	}
+
+	message := &Message{
+		To:       to,
+		From:     from,
+		Subject:  emailTemplate.Subject,
+		HTMLBody: htmlBody,
+		TextBody: textBody,
+		Headers:  map[string]string{},
+	}
+	for name, value := range emailTemplate.Headers {
+		message.Headers[name] = value
+	}
+	if emailTemplate.UnsubscribeURL != "" {
+		message.Headers["List-Unsubscribe"] = fmt.Sprintf("<%s>", emailTemplate.UnsubscribeURL)
+		message.Headers["List-Unsubscribe-Post"] = "List-Unsubscribe=One-Click"
 	}
 
-	body, err := json.Marshal(&email.Data{
-		To:          to,
-		From:        from,
-		Subject:     emailTemplate.Subject,
-		Body:        string(data),
-		ContentType: emailTemplate.ContentType,
-	})
+	if emailTemplate.IdempotencyKey == "" || EmailLedger == nil {
+		id, err := EmailSender.Send(ctx, message)
+		if err != nil {
+			return "", fmt.Errorf("failed to send email: %w", err)
+		}
+
+		return id, nil
+	}
+
+	claimed, err := EmailLedger.ClaimEmail(ctx, emailTemplate.IdempotencyKey)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal email.Data: %w", err)
+		return "", fmt.Errorf("failed to claim idempotency key: %w", err)
+	}
+	if !claimed {
+		log.Info(log.Fields{
+			"message":        "skipping email that has already been sent",
+			"idempotencyKey": emailTemplate.IdempotencyKey,
+		})
+		return "", nil
 	}
 
-	input := &sqs.SendMessageInput{
-		MessageBody: aws.String(string(body)),
-		QueueUrl:    aws.String(QueueURL),
+	id, err := EmailSender.Send(ctx, message)
+	if err != nil {
+		// Release the key so the email is sent when it's retried.
+		if releaseErr := EmailLedger.ReleaseEmail(ctx, emailTemplate.IdempotencyKey); releaseErr != nil {
+			log.Error(log.Fields{"error": releaseErr, "idempotencyKey": emailTemplate.IdempotencyKey})
+		}
+		return "", fmt.Errorf("failed to send email: %w", err)
 	}
 
-	output, err := SQSClient.SendMessage(ctx, input)
+	return id, nil
+}
+
+// renderText renders the plain-text part of an email.
+func renderText(emailTemplate EmailTemplate, htmlBody string) (string, error) {
+	name := strings.TrimSuffix(emailTemplate.FileName, ".html") + ".txt"
+
+	textBody, err := registry.RenderText(name, emailTemplate.Data)
+	if errors.Is(err, fs.ErrNotExist) {
+		return htmlToText(htmlBody), nil
+	}
 	if err != nil {
-		return "", fmt.Errorf("failed to send message to SQS: %w", err)
+		return "", fmt.Errorf("failed to render text template: %w", err)
 	}
 
-	return *output.MessageId, nil
+	return string(textBody), nil
 }
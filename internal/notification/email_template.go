@@ -1,19 +1,48 @@
 package notification
 
-import email "github.com/gofor-little/aws-email"
-
 type EmailTemplate struct {
-	FileName    string
-	Subject     string
-	ContentType email.ContentType
-	Data        interface{}
+	// FileName is the path of the HTML template under the templates directory. It's
+	// rendered into the shared layout, as is the sibling template with a .tmpl.txt
+	// extension used for the plain-text part.
+	FileName string
+	Subject  string
+	Data     interface{}
+	// UnsubscribeURL is advertised in the List-Unsubscribe header, along with the
+	// List-Unsubscribe-Post header for RFC 8058 one-click unsubscribes, if set. It must
+	// be an HTTPS URL that accepts the one-click POST. Every email sent to subscribers
+	// should set it.
+	UnsubscribeURL string
+	// Headers are extra headers added to the email.
+	Headers map[string]string
+	// IdempotencyKey identifies the email so it's only sent once, such as the
+	// subscription ID and the kind of email. The email isn't deduplicated if it's empty.
+	IdempotencyKey string
 }
 
 type SubscriptionConfirmationTemplateData struct {
-	WebsiteDomain  string
-	APIDomain      string
-	SubscriptionID string
-	EmailAddress   string
+	WebsiteDomain    string
+	APIDomain        string
+	ConfirmToken     string
+	UnsubscribeToken string
+}
+
+type WelcomeTemplateData struct {
+	WebsiteDomain    string
+	APIDomain        string
+	UnsubscribeToken string
+}
+
+type GoodbyeTemplateData struct {
+	WebsiteDomain string
+}
+
+type NewPostTemplateData struct {
+	WebsiteDomain    string
+	APIDomain        string
+	Title            string
+	URL              string
+	Summary          string
+	UnsubscribeToken string
 }
 
 type ReaderUnsubscribedTemplateData struct {
@@ -24,3 +53,18 @@ type RecaptchaChallengeFailedTemplateData struct {
 	EmailAddress string
 	Score        float32
 }
+
+type SubscriptionHeldTemplateData struct {
+	EmailAddress string
+	Score        float32
+}
+
+// OwnerDigestTemplateData batches the alerts sent to the site owner into one email.
+type OwnerDigestTemplateData struct {
+	WebsiteDomain             string
+	ReadersUnsubscribed       []ReaderUnsubscribedTemplateData
+	RecaptchaChallengesFailed []RecaptchaChallengeFailedTemplateData
+	SubscriptionsHeld         []SubscriptionHeldTemplateData
+	// Unlisted is the number of alerts left out of the lists because of their length.
+	Unlisted int
+}
@@ -0,0 +1,49 @@
+package notification_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+)
+
+type failingSender struct{}
+
+func (failingSender) Send(ctx context.Context, message *notification.Message) (string, error) {
+	return "", errors.New("failed")
+}
+
+func TestEnqueueEmailIdempotency(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	notification.EmailLedger = db.NewMemoryStore()
+	t.Cleanup(func() { notification.EmailLedger = nil })
+
+	emailTemplate := notification.EmailTemplate{
+		FileName:       "email/goodbye.tmpl.html",
+		Subject:        "Unsubscribed",
+		IdempotencyKey: "subscription-id#goodbye",
+		Data:           notification.GoodbyeTemplateData{},
+	}
+
+	// A failed send releases the key so the email is sent when it's retried.
+	notification.EmailSender = failingSender{}
+	_, err := notification.EnqueueEmail(context.Background(), []string{"reader@example.com"}, "author@example.com", emailTemplate)
+	require.Error(t, err)
+
+	notification.EmailSender = &notification.FileSender{Path: path}
+	for i := 0; i < 2; i++ {
+		_, err := notification.EnqueueEmail(context.Background(), []string{"reader@example.com"}, "author@example.com", emailTemplate)
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "Subject: Unsubscribed"))
+}
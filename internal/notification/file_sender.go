@@ -0,0 +1,82 @@
+package notification
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSender appends emails to an mbox file so they can be read with a mail client
+// during local development.
+type FileSender struct {
+	Path string
+
+	mutex sync.Mutex
+}
+
+// NewFileSender returns a FileSender that appends to the mbox file at the path. The
+// file is created if it doesn't exist.
+func NewFileSender(path string) (*FileSender, error) {
+	if len(path) == 0 {
+		return nil, errors.New("file path cannot be empty")
+	}
+
+	return &FileSender{
+		Path: path,
+	}, nil
+}
+
+// Send appends the email to the mbox file and returns its Message-ID.
+func (f *FileSender) Send(ctx context.Context, message *Message) (string, error) {
+	m, id, err := newMIMEMessage(message)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := &bytes.Buffer{}
+	if _, err := m.WriteTo(buffer); err != nil {
+		return "", fmt.Errorf("failed to write message: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to open mbox file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(mboxEntry(message.From, time.Now(), buffer.Bytes())); err != nil {
+		return "", fmt.Errorf("failed to write to mbox file: %w", err)
+	}
+
+	return id, nil
+}
+
+// mboxEntry formats a message as an mboxrd entry. Lines of the message that look like
+// an entry's From line are quoted with '>' so the message can be split out again.
+func mboxEntry(from string, date time.Time, message []byte) []byte {
+	entry := &bytes.Buffer{}
+	fmt.Fprintf(entry, "From %s %s\n", from, date.UTC().Format(time.ANSIC))
+
+	scanner := bufio.NewScanner(bytes.NewReader(message))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(message)+1)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			line = ">" + line
+		}
+		entry.WriteString(line)
+		entry.WriteString("\n")
+	}
+	entry.WriteString("\n")
+
+	return entry.Bytes()
+}
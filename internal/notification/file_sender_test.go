@@ -0,0 +1,47 @@
+package notification_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+)
+
+func TestFileSender(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mbox")
+	require.NoError(t, notification.Initialize(context.Background(), notification.Config{
+		Backend:  notification.BackendFile,
+		FilePath: path,
+	}))
+
+	for i := 0; i < 2; i++ {
+		_, err := notification.EnqueueEmail(context.Background(), []string{"reader@example.com"}, "author@example.com", notification.EmailTemplate{
+			FileName:       "email/new-post.tmpl.html",
+			Subject:        "New Post",
+			UnsubscribeURL: "https://api.example.com/unsubscribe?token=token",
+			Data: notification.NewPostTemplateData{
+				Title:   "Title",
+				URL:     "https://example.com/post",
+				Summary: "From the start.",
+			},
+		})
+		require.NoError(t, err)
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(data), "\nFrom author@example.com ")+1)
+	require.Contains(t, string(data), "Subject: New Post")
+	require.Contains(t, string(data), "To: reader@example.com")
+	require.Contains(t, string(data), "Message-ID: <")
+	require.Contains(t, string(data), "List-Unsubscribe: <https://api.example.com/unsubscribe?token=token>")
+	require.Contains(t, string(data), "List-Unsubscribe-Post: List-Unsubscribe=One-Click")
+	require.Contains(t, string(data), "Content-Type: multipart/alternative")
+	require.Contains(t, string(data), "Content-Type: text/plain")
+	require.Contains(t, string(data), "Content-Type: text/html")
+}
@@ -0,0 +1,260 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// Like the plain-text conversion, inlining only needs to handle the markup and CSS
+	// used by the email templates, so a few patterns are enough.
+	cssCommentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	selectorPattern   = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9]*)?((?:\.[a-zA-Z0-9_-]+)*)$`)
+	startTagPattern   = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)(\s[^<>]*)?>`)
+	classAttrPattern  = regexp.MustCompile(`(?i)\sclass\s*=\s*"([^"]*)"`)
+	styleAttrPattern  = regexp.MustCompile(`(?i)\sstyle\s*=\s*"([^"]*)"`)
+)
+
+// stylesheet is the CSS shared by every email. Its rules with simple selectors are
+// inlined into the style attributes of the elements they match, as many email clients
+// strip <style> blocks. At-rules, such as media queries, and rules with other selectors
+// can't be inlined, so they're kept in a <style> block for the clients that support
+// it. Their declarations need !important to override the inlined styles.
+type stylesheet struct {
+	rules []cssRule
+	// kept is the CSS that can't be inlined.
+	kept string
+}
+
+// cssRule is a rule with a single selector made of an optional element name and any
+// number of classes, such as "p", ".footer" or "a.button".
+type cssRule struct {
+	element      string
+	classes      []string
+	declarations []cssDeclaration
+}
+
+type cssDeclaration struct {
+	property string
+	value    string
+}
+
+// parseStylesheetFile reads and parses the stylesheet at the path.
+func parseStylesheetFile(fileSystem fs.FS, path string) (*stylesheet, error) {
+	data, err := fs.ReadFile(fileSystem, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stylesheet: %w", err)
+	}
+
+	return parseStylesheet(string(data))
+}
+
+// mustParseStylesheetFile is the same as parseStylesheetFile but panics if the
+// stylesheet is broken.
+func mustParseStylesheetFile(fileSystem fs.FS, path string) *stylesheet {
+	s, err := parseStylesheetFile(fileSystem, path)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse stylesheet %s: %v", path, err))
+	}
+
+	return s
+}
+
+func parseStylesheet(css string) (*stylesheet, error) {
+	css = cssCommentPattern.ReplaceAllString(css, "")
+	s := &stylesheet{}
+	kept := []string{}
+
+	for {
+		open := strings.Index(css, "{")
+		if open == -1 {
+			if strings.TrimSpace(css) != "" {
+				return nil, fmt.Errorf("unexpected text after the last rule: %q", strings.TrimSpace(css))
+			}
+			break
+		}
+
+		end := matchingBrace(css, open)
+		if end == -1 {
+			return nil, errors.New("unbalanced braces")
+		}
+
+		prelude := strings.TrimSpace(css[:open])
+		block := css[open+1 : end]
+		css = css[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			kept = append(kept, prelude+" {"+block+"}")
+			continue
+		}
+
+		declarations := parseDeclarations(block)
+		for _, selector := range strings.Split(prelude, ",") {
+			selector = strings.TrimSpace(selector)
+			match := selectorPattern.FindStringSubmatch(selector)
+			if selector == "" || match == nil {
+				kept = append(kept, selector+" {"+block+"}")
+				continue
+			}
+
+			s.rules = append(s.rules, cssRule{
+				element:      strings.ToLower(match[1]),
+				classes:      strings.FieldsFunc(match[2], func(r rune) bool { return r == '.' }),
+				declarations: declarations,
+			})
+		}
+	}
+
+	// More specific rules are applied after less specific ones, otherwise the order of
+	// the stylesheet is kept.
+	sort.SliceStable(s.rules, func(i, j int) bool {
+		return s.rules[i].specificity() < s.rules[j].specificity()
+	})
+	s.kept = strings.Join(kept, "\n")
+
+	return s, nil
+}
+
+// inline sets the style attributes of the elements in the body and adds the CSS that
+// can't be inlined to the head. Styles already in an element's style attribute take
+// precedence over the stylesheet.
+func (s *stylesheet) inline(body string) string {
+	head, rest := "", body
+	if i := strings.Index(strings.ToLower(body), "<body"); i != -1 {
+		head, rest = body[:i], body[i:]
+	}
+
+	rest = startTagPattern.ReplaceAllStringFunc(rest, func(tag string) string {
+		match := startTagPattern.FindStringSubmatch(tag)
+		element, attributes := strings.ToLower(match[1]), match[2]
+
+		classes := []string{}
+		if classAttr := classAttrPattern.FindStringSubmatch(attributes); classAttr != nil {
+			classes = strings.Fields(html.UnescapeString(classAttr[1]))
+		}
+
+		declarations := []cssDeclaration{}
+		for _, rule := range s.rules {
+			if rule.matches(element, classes) {
+				declarations = append(declarations, rule.declarations...)
+			}
+		}
+		if styleAttr := styleAttrPattern.FindStringSubmatch(attributes); styleAttr != nil {
+			declarations = append(declarations, parseDeclarations(html.UnescapeString(styleAttr[1]))...)
+			attributes = styleAttrPattern.ReplaceAllString(attributes, "")
+		}
+		if len(declarations) == 0 {
+			return tag
+		}
+
+		attributes = strings.TrimRight(attributes, " \t\r\n")
+		closing := ">"
+		if strings.HasSuffix(attributes, "/") {
+			attributes = strings.TrimRight(strings.TrimSuffix(attributes, "/"), " \t\r\n")
+			closing = " />"
+		}
+
+		return fmt.Sprintf(`<%s%s style="%s"%s`, match[1], attributes, html.EscapeString(formatDeclarations(declarations)), closing)
+	})
+
+	if s.kept != "" {
+		if i := strings.Index(strings.ToLower(head), "</head>"); i != -1 {
+			head = head[:i] + "<style>\n" + s.kept + "\n</style>\n" + head[i:]
+		}
+	}
+
+	return head + rest
+}
+
+func (r cssRule) specificity() int {
+	specificity := len(r.classes) * 10
+	if r.element != "" {
+		specificity++
+	}
+
+	return specificity
+}
+
+func (r cssRule) matches(element string, classes []string) bool {
+	if r.element != "" && r.element != element {
+		return false
+	}
+
+	for _, class := range r.classes {
+		found := false
+		for _, c := range classes {
+			if c == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchingBrace returns the index of the brace closing the one at open, or -1 if it
+// isn't closed.
+func matchingBrace(css string, open int) int {
+	depth := 0
+	for i := open; i < len(css); i++ {
+		switch css[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func parseDeclarations(block string) []cssDeclaration {
+	declarations := []cssDeclaration{}
+	for _, declaration := range strings.Split(block, ";") {
+		parts := strings.SplitN(declaration, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		property := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.Join(strings.Fields(parts[1]), " ")
+		if property == "" || value == "" {
+			continue
+		}
+		declarations = append(declarations, cssDeclaration{property: property, value: value})
+	}
+
+	return declarations
+}
+
+// formatDeclarations formats the declarations as a style attribute's value. Only the
+// last value of each property is kept, in the position it was first declared.
+func formatDeclarations(declarations []cssDeclaration) string {
+	values := map[string]string{}
+	properties := []string{}
+	for _, declaration := range declarations {
+		if _, ok := values[declaration.property]; !ok {
+			properties = append(properties, declaration.property)
+		}
+		values[declaration.property] = declaration.value
+	}
+
+	formatted := make([]string, len(properties))
+	for i, property := range properties {
+		formatted[i] = property + ": " + values[property]
+	}
+
+	return strings.Join(formatted, "; ")
+}
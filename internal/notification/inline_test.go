@@ -0,0 +1,28 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInline(t *testing.T) {
+	s, err := parseStylesheet(`
+/* Comments are ignored. */
+p, .note { margin: 0; color: black }
+p.note { color: grey; }
+a:hover { color: red }
+@media (prefers-color-scheme: dark) { p { color: white !important; } }
+`)
+	require.NoError(t, err)
+
+	body := `<html><head></head><body><p>One</p><p class="note" style="font-size: 12px">Two</p><br/><a href="/">Three</a></body></html>`
+	require.Equal(t, `<html><head><style>
+a:hover { color: red }
+@media (prefers-color-scheme: dark) { p { color: white !important; } }
+</style>
+</head><body><p style="margin: 0; color: black">One</p><p class="note" style="margin: 0; color: grey; font-size: 12px">Two</p><br/><a href="/">Three</a></body></html>`, s.inline(body))
+
+	_, err = parseStylesheet(`p { color: black`)
+	require.Error(t, err)
+}
@@ -0,0 +1,12 @@
+package notification
+
+import "context"
+
+// Ledger records the idempotency keys of the emails that have been sent so retried
+// sends of the same email are skipped. db.Store implements it.
+type Ledger interface {
+	// ClaimEmail records the key and reports false if it had already been recorded.
+	ClaimEmail(ctx context.Context, key string) (bool, error)
+	// ReleaseEmail deletes the key so the email can be sent again.
+	ReleaseEmail(ctx context.Context, key string) error
+}
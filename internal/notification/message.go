@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/go-mail/mail"
+	"github.com/gofor-little/xrand"
+)
+
+// Message is an email with an HTML body and a plain-text alternative. It is the body
+// of the messages on the email queue.
+type Message struct {
+	To       []string `json:"to"`
+	From     string   `json:"from"`
+	Subject  string   `json:"subject"`
+	HTMLBody string   `json:"htmlBody"`
+	TextBody string   `json:"textBody"`
+	// Headers are extra headers added to the message, such as List-Unsubscribe.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// newMIMEMessage builds the multipart/alternative MIME message of an email for the
+// senders that deliver it themselves. A Message-ID is generated and returned along
+// with the message.
+func newMIMEMessage(message *Message) (*mail.Message, string, error) {
+	id, err := xrand.UUIDV4()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate message id: %w", err)
+	}
+
+	m := mail.NewMessage()
+	m.SetHeader("Message-ID", fmt.Sprintf("<%s@millhouse.dev>", id))
+	m.SetHeader("From", message.From)
+	m.SetHeader("To", message.To...)
+	m.SetHeader("Subject", message.Subject)
+
+	// Set the extra headers in a stable order so messages are reproducible.
+	names := make([]string, 0, len(message.Headers))
+	for name := range message.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m.SetHeader(name, message.Headers[name])
+	}
+
+	// Clients display the last alternative they support so the HTML part goes last.
+	m.SetBody("text/plain", message.TextBody)
+	m.AddAlternative("text/html", message.HTMLBody)
+
+	return m, id, nil
+}
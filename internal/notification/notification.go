@@ -6,49 +6,39 @@ import (
 	"errors"
 	"fmt"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/tmpl"
 )
 
 var (
-	SQSClient *sqs.Client
-	QueueURL  string
+	// EmailSender delivers the emails built by the package.
+	EmailSender Sender
+	// EmailLedger deduplicates emails with an idempotency key. Emails aren't
+	// deduplicated if it's nil.
+	EmailLedger Ledger
 
 	//go:embed templates
 	templates embed.FS
+	// registry is parsed when the package is loaded so a broken template stops the
+	// program from starting rather than failing its first email.
+	registry = tmpl.MustNewRegistry(templates, "templates", nil)
+	// emailStylesheet is inlined into every rendered email.
+	emailStylesheet = mustParseStylesheetFile(templates, "templates/styles/email.css")
 )
 
-func Initialize(ctx context.Context, profile string, region string, queueURL string) error {
-	if len(queueURL) == 0 {
-		return errors.New("queue url cannot be empty")
-	}
-	QueueURL = queueURL
-
-	var cfg aws.Config
+// Initialize sets EmailSender to the Sender selected by the config.
+func Initialize(ctx context.Context, config Config) error {
 	var err error
-
-	if profile != "" && region != "" {
-		cfg, err = config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(profile), config.WithRegion(region))
-	} else {
-		cfg, err = config.LoadDefaultConfig(ctx)
-	}
+	EmailSender, err = NewSender(ctx, config)
 	if err != nil {
-		return fmt.Errorf("failed to load default config: %w", err)
+		return fmt.Errorf("failed to create %s email sender: %w", config.Backend, err)
 	}
 
-	SQSClient = sqs.NewFromConfig(cfg)
-
 	return nil
 }
 
 func checkPackage() error {
-	if SQSClient == nil {
-		return errors.New("notification.SQSClient is nil, have you called notification.Initialize()?")
-	}
-
-	if QueueURL == "" {
-		return errors.New("notification.QueueURL is empty, did you call notification.Initialize()?")
+	if EmailSender == nil {
+		return errors.New("notification.EmailSender is nil, have you called notification.Initialize()?")
 	}
 
 	return nil
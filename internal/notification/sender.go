@@ -0,0 +1,86 @@
+package notification
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/gofor-little/env"
+)
+
+// Sender delivers an email, or hands it off to something that will, and returns the
+// ID of the message it was sent as.
+type Sender interface {
+	Send(ctx context.Context, message *Message) (string, error)
+}
+
+// Backend is the name of a Sender implementation.
+type Backend string
+
+const (
+	// BackendSQS enqueues emails onto the email queue for the mailer lambda to send.
+	BackendSQS Backend = "sqs"
+	// BackendSES sends emails directly via SES.
+	BackendSES Backend = "ses"
+	// BackendSMTP sends emails directly to an SMTP server.
+	BackendSMTP Backend = "smtp"
+	// BackendFile appends emails to an mbox file for local development.
+	BackendFile Backend = "file"
+)
+
+// Config selects and configures the Sender used by the package.
+type Config struct {
+	Backend Backend
+
+	// The SQS and SES backends' options. Both the profile and region are optional if
+	// authentication can be achieved via another method.
+	Profile  string
+	Region   string
+	QueueURL string
+
+	// The SMTP backend's options.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// The file backend's options.
+	FilePath string
+}
+
+// ConfigFromEnv builds a Config from the EMAIL_BACKEND environment variable and the
+// variables of the selected backend. The backend defaults to BackendSQS.
+func ConfigFromEnv() (Config, error) {
+	config := Config{
+		Backend:      Backend(env.Get("EMAIL_BACKEND", string(BackendSQS))),
+		QueueURL:     env.Get("EMAIL_QUEUE_URL", ""),
+		SMTPHost:     env.Get("SMTP_HOST", ""),
+		SMTPUsername: env.Get("SMTP_USERNAME", ""),
+		SMTPPassword: env.Get("SMTP_PASSWORD", ""),
+		FilePath:     env.Get("EMAIL_FILE_PATH", ""),
+	}
+
+	var err error
+	config.SMTPPort, err = strconv.Atoi(env.Get("SMTP_PORT", "587"))
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to parse SMTP_PORT: %w", err)
+	}
+
+	return config, nil
+}
+
+// NewSender returns the Sender selected by the config.
+func NewSender(ctx context.Context, config Config) (Sender, error) {
+	switch config.Backend {
+	case BackendSQS:
+		return NewSQSSender(ctx, config.Profile, config.Region, config.QueueURL)
+	case BackendSES:
+		return NewSESSender(ctx, config.Profile, config.Region)
+	case BackendSMTP:
+		return NewSMTPSender(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword)
+	case BackendFile:
+		return NewFileSender(config.FilePath)
+	default:
+		return nil, fmt.Errorf("unknown email backend: %q", config.Backend)
+	}
+}
@@ -0,0 +1,62 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ses"
+	"github.com/aws/aws-sdk-go-v2/service/ses/types"
+)
+
+// SESSender sends emails directly via SES as raw MIME messages so every part and
+// header is delivered.
+type SESSender struct {
+	Client *ses.Client
+}
+
+// NewSESSender returns an SESSender. Both the profile and region parameters are
+// optional if authentication can be achieved via another method.
+func NewSESSender(ctx context.Context, profile string, region string) (*SESSender, error) {
+	var cfg aws.Config
+	var err error
+
+	if profile != "" && region != "" {
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(profile), config.WithRegion(region))
+	} else {
+		cfg, err = config.LoadDefaultConfig(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default config: %w", err)
+	}
+
+	return &SESSender{
+		Client: ses.NewFromConfig(cfg),
+	}, nil
+}
+
+// Send sends the email and returns the ID SES assigned to it.
+func (s *SESSender) Send(ctx context.Context, message *Message) (string, error) {
+	m, _, err := newMIMEMessage(message)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := &bytes.Buffer{}
+	if _, err := m.WriteTo(buffer); err != nil {
+		return "", fmt.Errorf("failed to write message: %w", err)
+	}
+
+	output, err := s.Client.SendRawEmail(ctx, &ses.SendRawEmailInput{
+		RawMessage: &types.RawMessage{
+			Data: buffer.Bytes(),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to send email via SES: %w", err)
+	}
+
+	return *output.MessageId, nil
+}
@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-mail/mail"
+)
+
+// SMTPSender sends emails directly to an SMTP server. STARTTLS is used if the server
+// supports it.
+type SMTPSender struct {
+	Dialer *mail.Dialer
+}
+
+// NewSMTPSender returns an SMTPSender for the server. The username and password are
+// optional if the server doesn't require authentication.
+func NewSMTPSender(host string, port int, username string, password string) (*SMTPSender, error) {
+	if len(host) == 0 {
+		return nil, errors.New("smtp host cannot be empty")
+	}
+
+	return &SMTPSender{
+		Dialer: mail.NewDialer(host, port, username, password),
+	}, nil
+}
+
+// Send sends the email and returns its Message-ID.
+func (s *SMTPSender) Send(ctx context.Context, message *Message) (string, error) {
+	m, id, err := newMIMEMessage(message)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.Dialer.DialAndSend(m); err != nil {
+		return "", fmt.Errorf("failed to send email via SMTP: %w", err)
+	}
+
+	return id, nil
+}
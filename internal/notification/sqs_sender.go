@@ -0,0 +1,64 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// SQSSender enqueues emails onto the email queue. The mailer lambda consumes the queue
+// and sends them with another Sender.
+type SQSSender struct {
+	Client   *sqs.Client
+	QueueURL string
+}
+
+// NewSQSSender returns an SQSSender for the queue. Both the profile and region
+// parameters are optional if authentication can be achieved via another method.
+func NewSQSSender(ctx context.Context, profile string, region string, queueURL string) (*SQSSender, error) {
+	if len(queueURL) == 0 {
+		return nil, errors.New("queue url cannot be empty")
+	}
+
+	var cfg aws.Config
+	var err error
+
+	if profile != "" && region != "" {
+		cfg, err = config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(profile), config.WithRegion(region))
+	} else {
+		cfg, err = config.LoadDefaultConfig(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load default config: %w", err)
+	}
+
+	return &SQSSender{
+		Client:   sqs.NewFromConfig(cfg),
+		QueueURL: queueURL,
+	}, nil
+}
+
+// Send enqueues the email and returns the ID of the SQS message. Emails are
+// deduplicated by EmailLedger before they're sent rather than by the queue.
+func (s *SQSSender) Send(ctx context.Context, message *Message) (string, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	input := &sqs.SendMessageInput{
+		MessageBody: aws.String(string(body)),
+		QueueUrl:    aws.String(s.QueueURL),
+	}
+	output, err := s.Client.SendMessage(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("failed to send message to SQS: %w", err)
+	}
+
+	return *output.MessageId, nil
+}
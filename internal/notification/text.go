@@ -0,0 +1,58 @@
+package notification
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	// The HTML to plain-text conversion only needs to handle the markup used by the
+	// email templates, so a few patterns are enough.
+	commentPattern   = regexp.MustCompile(`(?s)<!--.*?-->`)
+	ignoredPattern   = regexp.MustCompile(`(?is)<(head|style|script)\b.*?</(head|style|script)>`)
+	linkPattern      = regexp.MustCompile(`(?is)<a\b[^>]*?href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	lineBreakPattern = regexp.MustCompile(`(?i)<br\s*/?>`)
+	blockPattern     = regexp.MustCompile(`(?i)</?(p|div|h[1-6]|ul|ol|table|tr)\b[^>]*>`)
+	listItemPattern  = regexp.MustCompile(`(?i)<li\b[^>]*>`)
+	tagPattern       = regexp.MustCompile(`(?s)<[^>]*>`)
+	spacePattern     = regexp.MustCompile(`[ \t\r\f\v]+`)
+	blankLinePattern = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToText converts a rendered HTML email into plain text. Links are written as
+// their text followed by their URL so they can still be followed, block elements are
+// separated by blank lines and list items are bulleted.
+func htmlToText(body string) string {
+	text := commentPattern.ReplaceAllString(body, "")
+	text = ignoredPattern.ReplaceAllString(text, "")
+	text = linkPattern.ReplaceAllStringFunc(text, func(link string) string {
+		match := linkPattern.FindStringSubmatch(link)
+		url := html.UnescapeString(match[1])
+		label := strings.TrimSpace(tagPattern.ReplaceAllString(match[2], ""))
+
+		if label == "" || html.UnescapeString(label) == url {
+			return url
+		}
+		return label + " (" + url + ")"
+	})
+
+	// Newlines in the source are only formatting, so collapse them before the markup
+	// that produces line breaks is converted.
+	text = strings.ReplaceAll(text, "\n", " ")
+	text = lineBreakPattern.ReplaceAllString(text, "\n")
+	text = blockPattern.ReplaceAllString(text, "\n\n")
+	text = listItemPattern.ReplaceAllString(text, "\n- ")
+	text = tagPattern.ReplaceAllString(text, "")
+	text = html.UnescapeString(text)
+	text = spacePattern.ReplaceAllString(text, " ")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	text = strings.Join(lines, "\n")
+	text = blankLinePattern.ReplaceAllString(text, "\n\n")
+
+	return strings.TrimSpace(text) + "\n"
+}
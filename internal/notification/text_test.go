@@ -0,0 +1,18 @@
+package notification
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTMLToText(t *testing.T) {
+	body := `<p>Hi there!</p>
+<p>Read <a href="https://example.com/post?a=1&amp;b=2">the
+post</a> &amp; reply.</p>
+<ul><li>One</li><li>Two</li></ul>
+<p>Kind Regards,<br>
+Taliesin</p>`
+
+	require.Equal(t, "Hi there!\n\nRead the post (https://example.com/post?a=1&b=2) & reply.\n\n- One\n- Two\n\nKind Regards,\nTaliesin\n", htmlToText(body))
+}
@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+)
+
+// Relay publishes outbox entries by rendering their email and handing it to the
+// notification package. The token and notification packages must be initialized.
+type Relay struct {
+	Store         db.OutboxStore
+	FromAddress   string
+	APIDomain     string
+	WebsiteDomain string
+}
+
+// Publish sends the email of a pending entry and marks the entry published. An entry
+// whose email was sent but couldn't be marked is sent again when it's retried unless
+// notification.EmailLedger is set. The email's idempotency key is the subscription and
+// the kind of email, so two entries for the same email are only sent once too.
+func (r *Relay) Publish(ctx context.Context, entry *db.OutboxEntry) error {
+	if entry.Status != db.OutboxStatusPending {
+		return nil
+	}
+
+	emailTemplate, err := r.emailTemplate(entry)
+	if err != nil {
+		return err
+	}
+	emailTemplate.IdempotencyKey = fmt.Sprintf("%s#%s", entry.SubscriptionID, entry.Kind)
+
+	if _, err := notification.EnqueueEmail(ctx, []string{entry.EmailAddress}, r.FromAddress, emailTemplate); err != nil {
+		return fmt.Errorf("failed to enqueue %s email: %w", entry.Kind, err)
+	}
+
+	return r.Store.PublishOutboxEntry(ctx, entry)
+}
+
+// PublishPending publishes every pending entry created before the given time and
+// returns the number that were published. Entries that fail are logged and left
+// pending so they're retried on the next run.
+func (r *Relay) PublishPending(ctx context.Context, before time.Time) (int, error) {
+	entries, err := r.Store.GetPendingOutboxEntries(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	published, failed := 0, 0
+	for _, entry := range entries {
+		// Entries are oldest first so the rest were created after the cutoff too.
+		if !entry.CreatedAt.Before(before) {
+			break
+		}
+
+		if err := r.Publish(ctx, entry); err != nil {
+			log.Error(log.Fields{"error": err, "outboxEntryId": entry.ID})
+			failed++
+			continue
+		}
+		published++
+	}
+
+	if failed > 0 {
+		return published, fmt.Errorf("failed to publish %d outbox entries", failed)
+	}
+
+	return published, nil
+}
+
+// emailTemplate returns the template of the entry's email.
+func (r *Relay) emailTemplate(entry *db.OutboxEntry) (notification.EmailTemplate, error) {
+	switch entry.Kind {
+	case db.OutboxKindSubscriptionConfirmation:
+		confirmToken, err := token.Issue(token.ActionConfirm, entry.SubscriptionID, entry.EmailAddress, db.PendingSubscriptionTTL)
+		if err != nil {
+			return notification.EmailTemplate{}, err
+		}
+		unsubscribeToken, err := token.Issue(token.ActionUnsubscribe, entry.SubscriptionID, entry.EmailAddress, token.UnsubscribeTTL)
+		if err != nil {
+			return notification.EmailTemplate{}, err
+		}
+
+		return notification.EmailTemplate{
+			FileName:       "email/subscription-confirmation.tmpl.html",
+			Subject:        "Subscription Confirmation",
+			UnsubscribeURL: r.unsubscribeURL(unsubscribeToken),
+			Data: notification.SubscriptionConfirmationTemplateData{
+				WebsiteDomain:    r.WebsiteDomain,
+				APIDomain:        r.APIDomain,
+				ConfirmToken:     confirmToken,
+				UnsubscribeToken: unsubscribeToken,
+			},
+		}, nil
+	case db.OutboxKindWelcome:
+		unsubscribeToken, err := token.Issue(token.ActionUnsubscribe, entry.SubscriptionID, entry.EmailAddress, token.UnsubscribeTTL)
+		if err != nil {
+			return notification.EmailTemplate{}, err
+		}
+
+		return notification.EmailTemplate{
+			FileName:       "email/welcome.tmpl.html",
+			Subject:        "Welcome",
+			UnsubscribeURL: r.unsubscribeURL(unsubscribeToken),
+			Data: notification.WelcomeTemplateData{
+				WebsiteDomain:    r.WebsiteDomain,
+				APIDomain:        r.APIDomain,
+				UnsubscribeToken: unsubscribeToken,
+			},
+		}, nil
+	case db.OutboxKindGoodbye:
+		return notification.EmailTemplate{
+			FileName: "email/goodbye.tmpl.html",
+			Subject:  "Unsubscribed",
+			Data: notification.GoodbyeTemplateData{
+				WebsiteDomain: r.WebsiteDomain,
+			},
+		}, nil
+	default:
+		return notification.EmailTemplate{}, fmt.Errorf("unknown outbox entry kind: %q", entry.Kind)
+	}
+}
+
+func (r *Relay) unsubscribeURL(unsubscribeToken string) string {
+	return fmt.Sprintf("https://%s/unsubscribe?token=%s", r.APIDomain, unsubscribeToken)
+}
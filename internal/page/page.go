@@ -0,0 +1,21 @@
+package page
+
+import (
+	"embed"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/tmpl"
+)
+
+var (
+	//go:embed templates
+	templates embed.FS
+	// registry is parsed when the package is loaded so a broken page stops the lambdas
+	// serving it from starting.
+	registry = tmpl.MustNewRegistry(templates, "templates", nil)
+)
+
+// Render renders the page with the file name, such as "confirm-successful.tmpl.html",
+// into the shared layout.
+func Render(name string, data interface{}) ([]byte, error) {
+	return registry.Render(name, data)
+}
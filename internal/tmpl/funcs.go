@@ -0,0 +1,46 @@
+package tmpl
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Funcs returns the helpers available to the templates of every registry.
+//
+//	{{url .APIDomain "/unsubscribe" "token" .UnsubscribeToken}}
+//	{{date "2 January 2006" .CreatedAt}}
+func Funcs() map[string]interface{} {
+	return map[string]interface{}{
+		"url":  buildURL,
+		"date": formatDate,
+	}
+}
+
+// buildURL returns the HTTPS URL of the path on the domain with the query built from
+// pairs of keys and values.
+func buildURL(domain string, path string, query ...string) (string, error) {
+	if len(query)%2 != 0 {
+		return "", errors.New("query must be pairs of keys and values")
+	}
+
+	values := url.Values{}
+	for i := 0; i < len(query); i += 2 {
+		values.Add(query[i], query[i+1])
+	}
+
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     domain,
+		Path:     "/" + strings.TrimPrefix(path, "/"),
+		RawQuery: values.Encode(),
+	}
+
+	return u.String(), nil
+}
+
+// formatDate formats the time in UTC with the layout.
+func formatDate(layout string, t time.Time) string {
+	return t.UTC().Format(layout)
+}
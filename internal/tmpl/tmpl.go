@@ -2,28 +2,193 @@ package tmpl
 
 import (
 	"bytes"
-	"embed"
 	"fmt"
 	"html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
 )
 
-func NewTemplateFromFile(fileSystem embed.FS, path string, data interface{}) ([]byte, error) {
-	tmpl := template.New("template")
+const (
+	htmlExtension = ".tmpl.html"
+	textExtension = ".tmpl.txt"
 
-	fileData, err := fileSystem.ReadFile(path)
+	layoutsDir  = "layouts/"
+	partialsDir = "partials/"
+	// layoutName is the name of the template in layouts/ that pages are rendered into.
+	layoutName = "base"
+	// contentName is the name pages are parsed as so the layout can render them.
+	contentName = "content"
+)
+
+// Registry holds the templates of a file system, parsed once so rendering them doesn't
+// read or parse any files. HTML templates end in .tmpl.html and plain-text templates in
+// .tmpl.txt.
+//
+// Templates under layouts/ and partials/ are shared by every other template, called a
+// page, of the same kind. Shared templates are named after their file without the
+// extension, so partials/signature.tmpl.html is rendered with {{template "signature" .}}.
+// If there is a layouts/base template, pages are rendered into it as the "content"
+// template, otherwise they're rendered on their own. Pages can override the blocks of
+// the layout and partials by defining templates with the same name.
+type Registry struct {
+	html map[string]*template.Template
+	text map[string]*texttemplate.Template
+}
+
+// NewRegistry parses every template under the root directory of the file system. The
+// funcs are available to every template along with Funcs, overriding them if they have
+// the same name.
+func NewRegistry(fileSystem fs.FS, root string, funcs map[string]interface{}) (*Registry, error) {
+	allFuncs := Funcs()
+	for name, f := range funcs {
+		allFuncs[name] = f
+	}
+
+	htmlFiles, textFiles, err := readFiles(fileSystem, root)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file data: %w", err)
+		return nil, err
 	}
 
-	tmpl, err = tmpl.Parse(string(fileData))
+	r := &Registry{
+		html: map[string]*template.Template{},
+		text: map[string]*texttemplate.Template{},
+	}
+
+	htmlBase := template.New("").Funcs(template.FuncMap(allFuncs))
+	for name, data := range htmlFiles.shared {
+		if _, err := htmlBase.New(name).Parse(data); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+	}
+	for name, data := range htmlFiles.pages {
+		tmpl, err := htmlBase.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone shared templates: %w", err)
+		}
+		if _, err := tmpl.New(contentName).Parse(data); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		r.html[name] = tmpl
+	}
+
+	textBase := texttemplate.New("").Funcs(texttemplate.FuncMap(allFuncs))
+	for name, data := range textFiles.shared {
+		if _, err := textBase.New(name).Parse(data); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+	}
+	for name, data := range textFiles.pages {
+		tmpl, err := textBase.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to clone shared templates: %w", err)
+		}
+		if _, err := tmpl.New(contentName).Parse(data); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+		r.text[name] = tmpl
+	}
+
+	return r, nil
+}
+
+// MustNewRegistry is the same as NewRegistry but panics if a template is broken. It's
+// meant for package variables so a broken template stops the program from starting.
+func MustNewRegistry(fileSystem fs.FS, root string, funcs map[string]interface{}) *Registry {
+	r, err := NewRegistry(fileSystem, root, funcs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse template: %w", err)
+		panic(fmt.Sprintf("failed to create template registry: %v", err))
+	}
+
+	return r
+}
+
+// Render renders the HTML page with the path relative to the registry's root. If there
+// is no such page, the error wraps fs.ErrNotExist.
+func (r *Registry) Render(name string, data interface{}) ([]byte, error) {
+	tmpl, ok := r.html[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "render", Path: name, Err: fs.ErrNotExist}
 	}
 
 	buffer := &bytes.Buffer{}
-	if err := tmpl.Execute(buffer, data); err != nil {
+	if err := tmpl.ExecuteTemplate(buffer, entrypoint(tmpl.Lookup(layoutName) != nil), data); err != nil {
 		return nil, fmt.Errorf("failed to execute template: %w", err)
 	}
 
 	return buffer.Bytes(), nil
 }
+
+// RenderText is the same as Render but for plain-text pages, whose output isn't HTML
+// escaped.
+func (r *Registry) RenderText(name string, data interface{}) ([]byte, error) {
+	tmpl, ok := r.text[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "render", Path: name, Err: fs.ErrNotExist}
+	}
+
+	buffer := &bytes.Buffer{}
+	if err := tmpl.ExecuteTemplate(buffer, entrypoint(tmpl.Lookup(layoutName) != nil), data); err != nil {
+		return nil, fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// entrypoint returns the name of the template a page is executed from.
+func entrypoint(hasLayout bool) string {
+	if hasLayout {
+		return layoutName
+	}
+
+	return contentName
+}
+
+// files are the contents of the templates of one kind, keyed by their name.
+type files struct {
+	shared map[string]string
+	pages  map[string]string
+}
+
+// readFiles reads the HTML and plain-text templates under the root directory.
+func readFiles(fileSystem fs.FS, root string) (*files, *files, error) {
+	htmlFiles := &files{shared: map[string]string{}, pages: map[string]string{}}
+	textFiles := &files{shared: map[string]string{}, pages: map[string]string{}}
+
+	err := fs.WalkDir(fileSystem, root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		var f *files
+		var extension string
+		switch {
+		case strings.HasSuffix(filePath, htmlExtension):
+			f, extension = htmlFiles, htmlExtension
+		case strings.HasSuffix(filePath, textExtension):
+			f, extension = textFiles, textExtension
+		default:
+			return nil
+		}
+
+		data, err := fs.ReadFile(fileSystem, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read file data: %w", err)
+		}
+
+		name := strings.TrimPrefix(filePath, strings.TrimSuffix(root, "/")+"/")
+		if strings.HasPrefix(name, layoutsDir) || strings.HasPrefix(name, partialsDir) {
+			f.shared[strings.TrimSuffix(path.Base(name), extension)] = string(data)
+		} else {
+			f.pages[name] = string(data)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read templates: %w", err)
+	}
+
+	return htmlFiles, textFiles, nil
+}
@@ -0,0 +1,55 @@
+package tmpl_test
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/tmpl"
+)
+
+func TestRegistry(t *testing.T) {
+	fileSystem := fstest.MapFS{
+		"templates/layouts/base.tmpl.html":    {Data: []byte(`<main>{{template "content" .}}</main>{{template "footer" .}}`)},
+		"templates/partials/footer.tmpl.html": {Data: []byte(`{{block "footnote" .}}<small>default</small>{{end}}`)},
+		"templates/email/post.tmpl.html":      {Data: []byte(`<a href="{{url .Domain "/post" "id" .ID}}">{{date "2 Jan 2006" .Date}}</a>`)},
+		"templates/email/note.tmpl.html":      {Data: []byte(`{{shout .ID}}{{define "footnote"}}<small>override</small>{{end}}`)},
+		"templates/email/post.tmpl.txt":       {Data: []byte(`{{.ID}} & more`)},
+	}
+	data := map[string]interface{}{
+		"Domain": "example.com",
+		"ID":     "a&b",
+		"Date":   time.Date(2021, time.June, 12, 0, 0, 0, 0, time.UTC),
+	}
+
+	registry, err := tmpl.NewRegistry(fileSystem, "templates", map[string]interface{}{
+		"shout": func(s string) string { return s + "!" },
+	})
+	require.NoError(t, err)
+
+	body, err := registry.Render("email/post.tmpl.html", data)
+	require.NoError(t, err)
+	require.Equal(t, `<main><a href="https://example.com/post?id=a%26b">12 Jun 2021</a></main><small>default</small>`, string(body))
+
+	// Pages can override the blocks of the partials.
+	body, err = registry.Render("email/note.tmpl.html", data)
+	require.NoError(t, err)
+	require.Equal(t, `<main>a&amp;b!</main><small>override</small>`, string(body))
+
+	// Plain-text pages aren't escaped and have no layout here.
+	body, err = registry.RenderText("email/post.tmpl.txt", data)
+	require.NoError(t, err)
+	require.Equal(t, `a&b & more`, string(body))
+
+	_, err = registry.RenderText("email/note.tmpl.txt", data)
+	require.True(t, errors.Is(err, fs.ErrNotExist))
+
+	// Broken templates fail when the registry is created.
+	fileSystem["templates/email/broken.tmpl.html"] = &fstest.MapFile{Data: []byte(`{{if .ID}}`)}
+	_, err = tmpl.NewRegistry(fileSystem, "templates", nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,162 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Action is the action a token authorizes. A token issued for one action can't be
+// used for another.
+type Action string
+
+const (
+	ActionConfirm     Action = "confirm"
+	ActionUnsubscribe Action = "unsubscribe"
+)
+
+// UnsubscribeTTL is how long unsubscribe links in emails remain valid.
+const UnsubscribeTTL = 365 * 24 * time.Hour
+
+var (
+	Secret []byte
+
+	// ErrInvalid is returned when a token is malformed, has been tampered with or was
+	// issued for a different action.
+	ErrInvalid = errors.New("token is invalid")
+	// ErrExpired is returned when a token is valid but it has expired.
+	ErrExpired = errors.New("token has expired")
+)
+
+// Claims is the data encoded in a token.
+type Claims struct {
+	Action         Action `json:"a"`
+	SubscriptionID string `json:"i"`
+	EmailAddress   string `json:"e"`
+	ExpiresAt      int64  `json:"x"`
+}
+
+func Initialize(secret string) error {
+	if len(secret) == 0 {
+		return errors.New("secret cannot be empty")
+	}
+	Secret = []byte(secret)
+
+	return nil
+}
+
+// Issue returns a token authorizing action on the subscription that expires after ttl.
+// The claims are encrypted with AES-GCM, so the email address can't be read from the
+// URLs the token is used in and the claims can't be altered without invalidating it.
+func Issue(action Action, subscriptionID string, emailAddress string, ttl time.Duration) (string, error) {
+	if err := checkPackage(); err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(&Claims{
+		Action:         action,
+		SubscriptionID: subscriptionID,
+		EmailAddress:   emailAddress,
+		ExpiresAt:      time.Now().Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal claims: %w", err)
+	}
+
+	aead, err := newAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// The action is authenticated as additional data so a token can't be used for
+	// another action even before its claims are checked.
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, payload, []byte(action))), nil
+}
+
+// Parse decrypts the token, verifies its expiry and action and returns its claims.
+func Parse(token string, action Action) (*Claims, error) {
+	if err := checkPackage(); err != nil {
+		return nil, err
+	}
+
+	payload, err := decrypt(token, action)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := &Claims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalid
+	}
+
+	if claims.Action != action {
+		return nil, ErrInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+
+	return claims, nil
+}
+
+// decrypt returns the payload of a token.
+func decrypt(token string, action Action) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, ErrInvalid
+	}
+
+	payload, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(action))
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	return payload, nil
+}
+
+// newAEAD returns the cipher tokens are encrypted with. Its key is derived from
+// Secret so the secret itself is never used as a key.
+func newAEAD() (cipher.AEAD, error) {
+	mac := hmac.New(sha256.New, Secret)
+	mac.Write([]byte("token encryption key"))
+
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+func checkPackage() error {
+	if len(Secret) == 0 {
+		return errors.New("token.Secret is empty, did you call token.Initialize()?")
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package token_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+)
+
+func TestIssueAndParse(t *testing.T) {
+	require.NoError(t, token.Initialize("test-secret"))
+
+	tkn, err := token.Issue(token.ActionUnsubscribe, "id", "test@example.com", time.Hour)
+	require.NoError(t, err)
+
+	claims, err := token.Parse(tkn, token.ActionUnsubscribe)
+	require.NoError(t, err)
+	require.Equal(t, "id", claims.SubscriptionID)
+	require.Equal(t, "test@example.com", claims.EmailAddress)
+
+	_, err = token.Parse(tkn, token.ActionConfirm)
+	require.ErrorIs(t, err, token.ErrInvalid)
+
+	// The email address can't be read from the token.
+	data, err := base64.RawURLEncoding.DecodeString(tkn)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "test@example.com")
+
+	data[len(data)-1] ^= 1
+	_, err = token.Parse(base64.RawURLEncoding.EncodeToString(data), token.ActionUnsubscribe)
+	require.ErrorIs(t, err, token.ErrInvalid)
+
+	expired, err := token.Issue(token.ActionUnsubscribe, "id", "test@example.com", -time.Second)
+	require.NoError(t, err)
+	_, err = token.Parse(expired, token.ActionUnsubscribe)
+	require.ErrorIs(t, err, token.ErrExpired)
+}
@@ -0,0 +1,228 @@
+package xhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ErrNoInteraction is returned by a replaying Recorder for requests that aren't in its
+// cassette or whose interactions have all been replayed.
+var ErrNoInteraction = errors.New("no interaction in the cassette matches the request")
+
+// RecorderMode is whether a Recorder records or replays interactions.
+type RecorderMode string
+
+const (
+	// RecorderModeRecord sends requests with the recorder's client and adds them to the
+	// cassette.
+	RecorderModeRecord RecorderMode = "RECORD"
+	// RecorderModeReplay returns the responses in the cassette without sending requests.
+	RecorderModeReplay RecorderMode = "REPLAY"
+)
+
+// Cassette is a list of recorded interactions saved as JSON.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type RecordedRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Recorder is a Client that records interactions to a cassette file, or replays them
+// from it, so responses captured from a real service can be used in tests. Query
+// parameters and form fields named in Redact are replaced before interactions are
+// recorded or matched, so secrets aren't saved to the cassette.
+type Recorder struct {
+	Mode RecorderMode
+	// Path is the cassette's file.
+	Path string
+	// Client sends the requests being recorded.
+	Client Client
+	Redact []string
+
+	mutex    sync.Mutex
+	cassette *Cassette
+	replayed map[*Interaction]bool
+}
+
+// NewRecorder returns a recorder in the given mode. A replaying recorder loads its
+// cassette straight away and a recording one starts a new cassette.
+func NewRecorder(mode RecorderMode, path string, client Client, redact ...string) (*Recorder, error) {
+	r := &Recorder{
+		Mode:     mode,
+		Path:     path,
+		Client:   client,
+		Redact:   redact,
+		cassette: &Cassette{},
+		replayed: map[*Interaction]bool{},
+	}
+
+	switch mode {
+	case RecorderModeRecord:
+		if client == nil {
+			return nil, errors.New("client cannot be nil when recording")
+		}
+	case RecorderModeReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, r.cassette); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal cassette: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid recorder mode: %q", mode)
+	}
+
+	return r, nil
+}
+
+func (r *Recorder) Do(request *http.Request) (*http.Response, error) {
+	recorded, err := r.recordRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Mode == RecorderModeReplay {
+		return r.replay(request, recorded)
+	}
+
+	response, err := r.Client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.cassette.Interactions = append(r.cassette.Interactions, &Interaction{
+		Request: recorded,
+		Response: RecordedResponse{
+			StatusCode: response.StatusCode,
+			Header:     response.Header,
+			Body:       string(body),
+		},
+	})
+
+	return response, nil
+}
+
+// Save writes the recorded interactions to the cassette's file.
+func (r *Recorder) Save() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+
+	if err := os.WriteFile(r.Path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+
+	return nil
+}
+
+// replay returns the response of the first interaction matching the request that
+// hasn't been replayed yet.
+func (r *Recorder) replay(request *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, interaction := range r.cassette.Interactions {
+		if r.replayed[interaction] || interaction.Request != recorded {
+			continue
+		}
+		r.replayed[interaction] = true
+
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Header:        interaction.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(interaction.Response.Body))),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       request,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, recorded.Method, recorded.URL)
+}
+
+// recordRequest returns the redacted form of the request, replacing its body so it can
+// still be sent.
+func (r *Recorder) recordRequest(request *http.Request) (RecordedRequest, error) {
+	u := *request.URL
+	u.RawQuery = r.redact(u.Query()).Encode()
+
+	recorded := RecordedRequest{
+		Method: request.Method,
+		URL:    u.String(),
+	}
+
+	if request.Body == nil {
+		return recorded, nil
+	}
+
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return RecordedRequest{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+	request.Body.Close()
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	recorded.Body = string(body)
+
+	if request.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(recorded.Body)
+		if err != nil {
+			return RecordedRequest{}, fmt.Errorf("failed to parse request form: %w", err)
+		}
+		recorded.Body = r.redact(form).Encode()
+	}
+
+	return recorded, nil
+}
+
+func (r *Recorder) redact(values url.Values) url.Values {
+	for _, key := range r.Redact {
+		if _, ok := values[key]; ok {
+			values.Set(key, "REDACTED")
+		}
+	}
+
+	return values
+}
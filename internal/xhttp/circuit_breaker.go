@@ -0,0 +1,99 @@
+package xhttp
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned instead of sending a request while the circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitBreaker stops sending requests to a failing server for a while so callers fail
+// fast instead of waiting on timeouts. Requests that return an error or a 5xx response
+// are failures. After Threshold consecutive failures the circuit opens and requests
+// fail with ErrCircuitOpen until Cooldown has passed. A single trial request is then
+// let through, closing the circuit if it succeeds and opening it again if it fails.
+//
+// A CircuitBreaker is safe for concurrent use and should be shared by every request to
+// the same server, such as by creating it once when a Lambda starts.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+	trialing bool
+}
+
+// NewCircuitBreaker returns a closed circuit breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}
+}
+
+// Middleware sends requests through the circuit breaker.
+func (c *CircuitBreaker) Middleware(next Client) Client {
+	return ClientFunc(func(request *http.Request) (*http.Response, error) {
+		if !c.allow() {
+			return nil, ErrCircuitOpen
+		}
+
+		response, err := next.Do(request)
+		// Requests cancelled by the caller, or not sent as there wasn't enough time left
+		// before the deadline, don't say anything about the server.
+		if err != nil && (request.Context().Err() != nil || errors.Is(err, ErrBudgetExhausted)) {
+			c.release()
+			return response, err
+		}
+		c.record(err == nil && response.StatusCode < http.StatusInternalServerError)
+
+		return response, err
+	})
+}
+
+// allow reports whether a request can be sent, starting a trial if the cooldown has
+// passed.
+func (c *CircuitBreaker) allow() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.failures < c.Threshold {
+		return true
+	}
+	if c.trialing || time.Since(c.openedAt) < c.Cooldown {
+		return false
+	}
+	c.trialing = true
+
+	return true
+}
+
+// record counts the outcome of a request.
+func (c *CircuitBreaker) record(success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.trialing = false
+	if success {
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures >= c.Threshold {
+		c.openedAt = time.Now()
+	}
+}
+
+// release ends a trial without counting its outcome so another can be started.
+func (c *CircuitBreaker) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.trialing = false
+}
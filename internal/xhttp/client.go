@@ -1,7 +1,23 @@
 package xhttp
 
-import "net/http"
+import (
+	"net/http"
+	"time"
+)
 
 type Client interface {
 	Do(*http.Request) (*http.Response, error)
 }
+
+// NewResilientClient returns a client that retries failed attempts with the retry
+// options, fails fast through the circuit breaker and limits each attempt with Timeout.
+// The circuit breaker is optional.
+func NewResilientClient(client Client, retryOptions RetryOptions, breaker *CircuitBreaker, perAttempt time.Duration, reserve time.Duration) Client {
+	middleware := []Middleware{Retry(retryOptions)}
+	if breaker != nil {
+		middleware = append(middleware, breaker.Middleware)
+	}
+	middleware = append(middleware, Timeout(perAttempt, reserve))
+
+	return Chain(client, middleware...)
+}
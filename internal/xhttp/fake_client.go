@@ -0,0 +1,242 @@
+package xhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// ErrNoRoute is returned by a FakeClient for requests that don't match any route.
+var ErrNoRoute = errors.New("no route matches the request")
+
+// FakeClient is a Client for tests that returns scripted responses. Routes are added
+// with On and matched in the order they were added. Every request is recorded, whether
+// it matched or not, so it can be asserted on with Calls.
+type FakeClient struct {
+	mutex  sync.Mutex
+	routes []*Route
+	calls  []*Call
+}
+
+// Call is a request received by a FakeClient.
+type Call struct {
+	Method string
+	URL    *url.URL
+	Header http.Header
+	Body   []byte
+}
+
+// Form parses the call's body as a URL encoded form.
+func (c *Call) Form() (url.Values, error) {
+	return url.ParseQuery(string(c.Body))
+}
+
+// Route matches requests and returns its queued responses in order. The last response
+// is repeated once the others have been returned.
+type Route struct {
+	method    string
+	url       *url.URL
+	delay     time.Duration
+	responses []fakeResponse
+}
+
+type fakeResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	err        error
+}
+
+// NewFakeClient returns a FakeClient without any routes.
+func NewFakeClient() *FakeClient {
+	return &FakeClient{}
+}
+
+// On adds a route matching requests with the method and URL. An empty method matches
+// every method. The request's URL must have the same scheme, host and path, and every
+// query parameter in rawURL, although the request may have other parameters too.
+func (f *FakeClient) On(method string, rawURL string) *Route {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse route URL %q: %v", rawURL, err))
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	route := &Route{method: method, url: u}
+	f.routes = append(f.routes, route)
+
+	return route
+}
+
+// Respond queues a response with the status code and body.
+func (r *Route) Respond(statusCode int, body string) *Route {
+	r.responses = append(r.responses, fakeResponse{statusCode: statusCode, header: http.Header{}, body: []byte(body)})
+	return r
+}
+
+// RespondJSON queues a response with the status code and v encoded as JSON.
+func (r *Route) RespondJSON(statusCode int, v interface{}) *Route {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("failed to marshal response body: %v", err))
+	}
+
+	r.responses = append(r.responses, fakeResponse{
+		statusCode: statusCode,
+		header:     http.Header{"Content-Type": []string{"application/json"}},
+		body:       body,
+	})
+
+	return r
+}
+
+// RespondError queues an error returned instead of a response.
+func (r *Route) RespondError(err error) *Route {
+	r.responses = append(r.responses, fakeResponse{err: err})
+	return r
+}
+
+// Delay waits before returning each of the route's responses, or until the request's
+// context is done.
+func (r *Route) Delay(delay time.Duration) *Route {
+	r.delay = delay
+	return r
+}
+
+func (f *FakeClient) Do(request *http.Request) (*http.Response, error) {
+	call, err := newCall(request)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	f.calls = append(f.calls, call)
+	route := f.match(request)
+	var response fakeResponse
+	if route != nil {
+		response = route.next()
+	}
+	f.mutex.Unlock()
+
+	if route == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoRoute, request.Method, request.URL)
+	}
+
+	if route.delay > 0 {
+		timer := time.NewTimer(route.delay)
+		select {
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	if response.err != nil {
+		return nil, response.err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.statusCode, http.StatusText(response.statusCode)),
+		StatusCode:    response.statusCode,
+		Header:        response.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(response.body)),
+		ContentLength: int64(len(response.body)),
+		Request:       request,
+	}, nil
+}
+
+// Calls returns the requests received so far, oldest first.
+func (f *FakeClient) Calls() []*Call {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]*Call{}, f.calls...)
+}
+
+// match returns the first route matching the request or nil if none do.
+func (f *FakeClient) match(request *http.Request) *Route {
+	for _, route := range f.routes {
+		if route.matches(request) {
+			return route
+		}
+	}
+
+	return nil
+}
+
+func (r *Route) matches(request *http.Request) bool {
+	if r.method != "" && r.method != request.Method {
+		return false
+	}
+	if r.url.Scheme != request.URL.Scheme || r.url.Host != request.URL.Host || r.url.Path != request.URL.Path {
+		return false
+	}
+
+	query := request.URL.Query()
+	for key, values := range r.url.Query() {
+		if !equalValues(query[key], values) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func equalValues(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// next returns the route's next response, repeating the last one. A route without any
+// responses returns an empty 200 response.
+func (r *Route) next() fakeResponse {
+	if len(r.responses) == 0 {
+		return fakeResponse{statusCode: http.StatusOK, header: http.Header{}}
+	}
+
+	response := r.responses[0]
+	if len(r.responses) > 1 {
+		r.responses = r.responses[1:]
+	}
+
+	return response
+}
+
+// newCall records the request, replacing its body so it can still be read.
+func newCall(request *http.Request) (*Call, error) {
+	u := *request.URL
+	call := &Call{
+		Method: request.Method,
+		URL:    &u,
+		Header: request.Header.Clone(),
+	}
+
+	if request.Body != nil {
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		request.Body.Close()
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		call.Body = body
+	}
+
+	return call, nil
+}
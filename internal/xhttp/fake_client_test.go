@@ -0,0 +1,104 @@
+package xhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+func TestFakeClient(t *testing.T) {
+	client := xhttp.NewFakeClient()
+	client.On(http.MethodGet, "https://example.com/items?page=2").Respond(http.StatusOK, "second page")
+	client.On(http.MethodGet, "https://example.com/items").
+		Respond(http.StatusServiceUnavailable, "unavailable").
+		RespondJSON(http.StatusOK, map[string]string{"page": "1"})
+	client.On(http.MethodPost, "https://example.com/items").RespondError(errors.New("connection reset"))
+	client.On("", "https://example.com/slow").Delay(time.Second)
+
+	// Queued responses are returned in order and the last is repeated.
+	for _, expected := range []string{"unavailable", `{"page":"1"}`, `{"page":"1"}`} {
+		response, err := get(client, "https://example.com/items?page=1")
+		require.NoError(t, err)
+		body, err := io.ReadAll(response.Body)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(body))
+	}
+
+	response, err := get(client, "https://example.com/items?page=2&sort=asc")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	request, err := http.NewRequest(http.MethodPost, "https://example.com/items", strings.NewReader("name=test"))
+	require.NoError(t, err)
+	_, err = client.Do(request)
+	require.EqualError(t, err, "connection reset")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	request, err = http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com/slow", nil)
+	require.NoError(t, err)
+	_, err = client.Do(request)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	_, err = get(client, "https://example.com/missing")
+	require.True(t, errors.Is(err, xhttp.ErrNoRoute))
+
+	calls := client.Calls()
+	require.Len(t, calls, 7)
+	require.Equal(t, http.MethodPost, calls[4].Method)
+	form, err := calls[4].Form()
+	require.NoError(t, err)
+	require.Equal(t, "test", form.Get("name"))
+}
+
+func TestRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	fake := xhttp.NewFakeClient()
+	fake.On(http.MethodPost, "https://example.com/verify").Respond(http.StatusOK, `{"success":true}`)
+
+	recorder, err := xhttp.NewRecorder(xhttp.RecorderModeRecord, path, fake, "secret")
+	require.NoError(t, err)
+	_, err = recorder.Do(newFormRequest(t, "secret=hunter2&response=token"))
+	require.NoError(t, err)
+	require.NoError(t, recorder.Save())
+
+	// The secret isn't saved so any secret matches when replaying.
+	replayer, err := xhttp.NewRecorder(xhttp.RecorderModeReplay, path, nil, "secret")
+	require.NoError(t, err)
+	response, err := replayer.Do(newFormRequest(t, "secret=other&response=token"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(response.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"success":true}`, string(body))
+
+	// Each interaction is only replayed once.
+	_, err = replayer.Do(newFormRequest(t, "secret=other&response=token"))
+	require.True(t, errors.Is(err, xhttp.ErrNoInteraction))
+	require.Len(t, fake.Calls(), 1)
+}
+
+func get(client xhttp.Client, rawURL string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Do(request)
+}
+
+func newFormRequest(t *testing.T, body string) *http.Request {
+	request, err := http.NewRequest(http.MethodPost, "https://example.com/verify", strings.NewReader(body))
+	require.NoError(t, err)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return request
+}
@@ -0,0 +1,23 @@
+package xhttp
+
+import "net/http"
+
+// ClientFunc adapts a function to the Client interface.
+type ClientFunc func(*http.Request) (*http.Response, error)
+
+func (f ClientFunc) Do(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
+
+// Middleware wraps a Client to change how its requests are sent.
+type Middleware func(next Client) Client
+
+// Chain wraps the client in the middleware. The first middleware is the outermost, so
+// it sees each request first and each response last.
+func Chain(client Client, middleware ...Middleware) Client {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		client = middleware[i](client)
+	}
+
+	return client
+}
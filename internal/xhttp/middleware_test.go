@@ -0,0 +1,150 @@
+package xhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+)
+
+func TestRetry(t *testing.T) {
+	attempts := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "body", string(body))
+
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := xhttp.Chain(server.Client(), xhttp.Retry(xhttp.RetryOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+
+	// The body is sent again with each attempt.
+	request, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("body"))
+	require.NoError(t, err)
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+
+	// Client errors aren't retried.
+	attempts = 0
+	client = xhttp.Chain(xhttp.ClientFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &http.Response{StatusCode: http.StatusBadRequest, Body: http.NoBody}, nil
+	}), xhttp.Retry(xhttp.DefaultRetryOptions))
+	request, err = http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, response.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	// With UnsentOnly, requests the server may have received aren't retried.
+	attempts = 0
+	options := xhttp.RetryOptions{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, UnsentOnly: true}
+	client = xhttp.Chain(xhttp.ClientFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return &http.Response{StatusCode: http.StatusBadGateway, Body: http.NoBody}, nil
+	}), xhttp.Retry(options))
+	response, err = client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadGateway, response.StatusCode)
+	require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+
+	// But requests that couldn't connect are.
+	attempts = 0
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	client = xhttp.Chain(xhttp.ClientFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		return closed.Client().Do(r)
+	}), xhttp.Retry(options))
+	request, err = http.NewRequest(http.MethodPost, closed.URL, strings.NewReader("body"))
+	require.NoError(t, err)
+	_, err = client.Do(request)
+	require.Error(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	failing := true
+	attempts := 0
+	breaker := xhttp.NewCircuitBreaker(2, 50*time.Millisecond)
+	client := xhttp.Chain(xhttp.ClientFunc(func(r *http.Request) (*http.Response, error) {
+		attempts++
+		if failing {
+			return nil, errors.New("connection refused")
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	}), breaker.Middleware)
+
+	request, err := http.NewRequest(http.MethodGet, "https://example.com", nil)
+	require.NoError(t, err)
+
+	// The circuit opens after two failures.
+	for i := 0; i < 2; i++ {
+		_, err = client.Do(request)
+		require.Error(t, err)
+		require.False(t, errors.Is(err, xhttp.ErrCircuitOpen))
+	}
+	_, err = client.Do(request)
+	require.True(t, errors.Is(err, xhttp.ErrCircuitOpen))
+	require.Equal(t, 2, attempts)
+
+	// A trial request is let through after the cooldown and closes the circuit.
+	time.Sleep(60 * time.Millisecond)
+	failing = false
+	response, err := client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	_, err = client.Do(request)
+	require.NoError(t, err)
+	require.Equal(t, 4, attempts)
+}
+
+func TestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	client := xhttp.Chain(server.Client(), xhttp.Timeout(20*time.Millisecond, 0))
+	request, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+	_, err = client.Do(request)
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// Requests aren't sent without enough time left before the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	client = xhttp.Chain(server.Client(), xhttp.Timeout(time.Second, 100*time.Millisecond))
+	_, err = client.Do(request.WithContext(ctx))
+	require.True(t, errors.Is(err, xhttp.ErrBudgetExhausted))
+
+	// Requests that weren't sent aren't failures, so they don't open the circuit.
+	breaker := xhttp.NewCircuitBreaker(1, time.Minute)
+	client = xhttp.NewResilientClient(server.Client(), xhttp.RetryOptions{MaxAttempts: 1}, breaker, time.Second, 100*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		_, err = client.Do(request.WithContext(ctx))
+		require.True(t, errors.Is(err, xhttp.ErrBudgetExhausted))
+	}
+}
@@ -0,0 +1,150 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryOptions configures the Retry middleware.
+type RetryOptions struct {
+	// MaxAttempts is the most times a request is sent, including the first attempt.
+	MaxAttempts int
+	// BaseDelay is the longest wait before the first retry. It doubles for each retry
+	// after that.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts.
+	MaxDelay time.Duration
+	// UnsentOnly only retries attempts that failed before the request was sent, such as
+	// when the connection couldn't be made. Set it for requests that mustn't reach the
+	// server twice, such as verifying a single use token.
+	UnsentOnly bool
+}
+
+// DefaultRetryOptions retries a request twice, waiting up to 100ms and then 200ms.
+var DefaultRetryOptions = RetryOptions{
+	MaxAttempts: 3,
+	BaseDelay:   100 * time.Millisecond,
+	MaxDelay:    time.Second,
+}
+
+var (
+	jitterMutex  sync.Mutex
+	jitterSource = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Retry resends requests that timed out or got a 5xx response, or with UnsentOnly the
+// requests that couldn't be sent, waiting a random
+// duration up to an exponentially growing delay between attempts. The response of the
+// last attempt is returned if they all fail. Requests with a body are only retried if
+// the body can be read again through GetBody, as requests built by http.NewRequest
+// can. A retry isn't attempted if its delay would pass the request context's deadline.
+func Retry(options RetryOptions) Middleware {
+	return func(next Client) Client {
+		return ClientFunc(func(request *http.Request) (*http.Response, error) {
+			for attempt := 1; ; attempt++ {
+				response, err := next.Do(request)
+				if attempt >= options.MaxAttempts || !options.isRetryable(request, response, err) {
+					return response, err
+				}
+
+				delay := options.delay(attempt)
+				if deadline, ok := request.Context().Deadline(); ok && time.Until(deadline) < delay {
+					return response, err
+				}
+
+				if response != nil && response.Body != nil {
+					_, _ = io.Copy(io.Discard, response.Body)
+					response.Body.Close()
+				}
+
+				timer := time.NewTimer(delay)
+				select {
+				case <-request.Context().Done():
+					timer.Stop()
+					return nil, request.Context().Err()
+				case <-timer.C:
+				}
+
+				if request, err = rewind(request); err != nil {
+					return nil, err
+				}
+			}
+		})
+	}
+}
+
+// delay returns a random duration between zero and the attempt's exponential delay.
+func (o RetryOptions) delay(attempt int) time.Duration {
+	delay := o.BaseDelay << (attempt - 1)
+	if delay > o.MaxDelay || delay <= 0 {
+		delay = o.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+
+	jitterMutex.Lock()
+	defer jitterMutex.Unlock()
+
+	return time.Duration(jitterSource.Int63n(int64(delay) + 1))
+}
+
+// isRetryable reports whether the attempt failed in a way another attempt might not.
+func (o RetryOptions) isRetryable(request *http.Request, response *http.Response, err error) bool {
+	if request.Context().Err() != nil {
+		return false
+	}
+	if request.Body != nil && request.Body != http.NoBody && request.GetBody == nil {
+		return false
+	}
+	if o.UnsentOnly {
+		return err != nil && isUnsent(err)
+	}
+	if err != nil {
+		return isTimeout(err)
+	}
+
+	return response.StatusCode >= http.StatusInternalServerError
+}
+
+// isUnsent reports whether the error happened before the request was sent because the
+// connection couldn't be made.
+func isUnsent(err error) bool {
+	opErr := (*net.OpError)(nil)
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// isTimeout reports whether the error is a timeout, either of the client or of the
+// attempt's context.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	netErr := net.Error(nil)
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// rewind returns a copy of the request with a fresh body to send again.
+func rewind(request *http.Request) (*http.Request, error) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return request, nil
+	}
+
+	body, err := request.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request body: %w", err)
+	}
+
+	request = request.Clone(request.Context())
+	request.Body = body
+
+	return request, nil
+}
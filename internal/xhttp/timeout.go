@@ -0,0 +1,59 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrBudgetExhausted is returned instead of sending a request when there isn't enough
+// time left before the request context's deadline.
+var ErrBudgetExhausted = errors.New("not enough time left before the deadline to send the request")
+
+// Timeout limits each attempt of a request to perAttempt and to the time left before
+// the request context's deadline, less reserve. A Lambda's context has the invocation's
+// deadline, so reserve is the time kept back for the handler to respond after the
+// request. A perAttempt of zero only limits attempts by the deadline.
+func Timeout(perAttempt time.Duration, reserve time.Duration) Middleware {
+	return func(next Client) Client {
+		return ClientFunc(func(request *http.Request) (*http.Response, error) {
+			timeout := perAttempt
+			if deadline, ok := request.Context().Deadline(); ok {
+				remaining := time.Until(deadline) - reserve
+				if remaining <= 0 {
+					return nil, ErrBudgetExhausted
+				}
+				if timeout <= 0 || remaining < timeout {
+					timeout = remaining
+				}
+			}
+			if timeout <= 0 {
+				return next.Do(request)
+			}
+
+			ctx, cancel := context.WithTimeout(request.Context(), timeout)
+			response, err := next.Do(request.WithContext(ctx))
+			if err != nil || response.Body == nil {
+				cancel()
+				return response, err
+			}
+
+			// The body is read after Do returns so the context is cancelled when it's closed.
+			response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+
+			return response, nil
+		})
+	}
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelBody) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
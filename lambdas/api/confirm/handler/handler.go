@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/xlambda"
+	"github.com/gofor-little/xrand"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/page"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+)
+
+var Store db.SubscriptionStore
+
+func Handler(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	successTemplate, err := page.Render("confirm-successful.tmpl.html", nil)
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to render page: %w", err), nil)
+	}
+
+	failedTemplate, err := page.Render("confirm-failed.tmpl.html", nil)
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to render page: %w", err), nil)
+	}
+
+	data := &RequestData{}
+	if err := xlambda.ParseAndValidate(request, data); err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusBadRequest, err, nil)
+	}
+
+	claims, err := token.Parse(data.Token, token.ActionConfirm)
+	if errors.Is(err, token.ErrExpired) {
+		return xlambda.ProxyResponseHTML(http.StatusNotFound, nil, failedTemplate)
+	}
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusBadRequest, fmt.Errorf("failed to parse token: %w", err), nil)
+	}
+
+	subscription, err := Store.GetSubscription(ctx, claims.EmailAddress)
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to get subscription: %w", err), nil)
+	}
+
+	// The subscription has either expired and been deleted, been unsubscribed or the
+	// link belongs to an older subscription for the same email address.
+	if subscription == nil || subscription.ID != claims.SubscriptionID {
+		return xlambda.ProxyResponseHTML(http.StatusNotFound, nil, failedTemplate)
+	}
+
+	switch subscription.Status {
+	case db.SubscriptionStatusConfirmed:
+		return xlambda.ProxyResponseHTML(http.StatusOK, nil, successTemplate)
+	case db.SubscriptionStatusPending:
+		if subscription.IsExpired() {
+			return xlambda.ProxyResponseHTML(http.StatusNotFound, nil, failedTemplate)
+		}
+	default:
+		return xlambda.ProxyResponseHTML(http.StatusNotFound, nil, failedTemplate)
+	}
+
+	entryID, err := xrand.UUIDV4()
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to generate UUID: %w", err), nil)
+	}
+
+	// The welcome email is sent from the outbox, which is written in the same transaction
+	// as the confirmation.
+	subscription.Confirm()
+	if err := Store.UpdateSubscription(ctx, subscription, db.NewOutboxEntry(entryID, db.OutboxKindWelcome, subscription)); err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to confirm subscription: %w", err), nil)
+	}
+
+	return xlambda.ProxyResponseHTML(http.StatusOK, nil, successTemplate)
+}
+
+type RequestData struct {
+	Token string `mapstructure:"token"`
+}
+
+func (r *RequestData) Validate() error {
+	if len(r.Token) == 0 {
+		return errors.New("token cannot be empty")
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofor-little/log"
+	"github.com/gofor-little/xlambda"
+	"github.com/gofor-little/xrand"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/api/confirm/handler"
+)
+
+func TestHandler(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	require.NoError(t, token.Initialize("test-secret"))
+	handler.Store = db.NewMemoryStore()
+
+	id, err := xrand.UUIDV4()
+	require.NoError(t, err)
+	subscription := db.NewSubscription(id, "test@example.com")
+	require.NoError(t, handler.Store.CreateSubscription(context.Background(), subscription))
+
+	tkn, err := token.Issue(token.ActionConfirm, subscription.ID, subscription.EmailAddress, time.Hour)
+	require.NoError(t, err)
+
+	request, err := xlambda.ProxyRequest(http.MethodGet, map[string]string{
+		"token": tkn,
+	}, nil)
+	require.NoError(t, err)
+
+	response, err := handler.Handler(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	subscription, err = handler.Store.GetSubscription(context.Background(), subscription.EmailAddress)
+	require.NoError(t, err)
+	require.Equal(t, db.SubscriptionStatusConfirmed, subscription.Status)
+	require.Zero(t, subscription.ExpiresAt)
+
+	// Tokens issued for a different subscription with the same email address are rejected.
+	tkn, err = token.Issue(token.ActionConfirm, "other-id", subscription.EmailAddress, time.Hour)
+	require.NoError(t, err)
+
+	request, err = xlambda.ProxyRequest(http.MethodGet, map[string]string{
+		"token": tkn,
+	}, nil)
+	require.NoError(t, err)
+
+	response, err = handler.Handler(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, response.StatusCode)
+}
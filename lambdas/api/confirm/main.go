@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gofor-little/cfg"
+	"github.com/gofor-little/env"
+	"github.com/gofor-little/log"
+	"github.com/gofor-little/xlambda"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/api/confirm/handler"
+)
+
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	var err error
+	handler.Store, err = db.NewDynamoDBStore(context.Background(), "", "", env.Get("TABLE_NAME", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the subscription store: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := xlambda.Initialize(env.Get("ACCESS_CONTROL_ALLOW_ORIGIN", "*")); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the xlambda package: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := cfg.Initialize(context.Background(), "", ""); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the cfg package: %w", err)})
+		os.Exit(1)
+	}
+
+	tokenSecret, err := cfg.LoadString(context.Background(), env.Get("TOKEN_SECRET_ARN", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load token secret: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := token.Initialize(tokenSecret); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the token package: %w", err)})
+		os.Exit(1)
+	}
+
+	lambda.Start(handler.Handler)
+}
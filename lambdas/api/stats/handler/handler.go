@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/xlambda"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+)
+
+var (
+	Store db.SubscriptionStore
+
+	// CacheMaxAge is the number of seconds clients and CDNs may cache the counts for.
+	CacheMaxAge = 300
+)
+
+func Handler(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	confirmed, err := Store.GetSubscriptionCount(ctx, db.SubscriptionStatusConfirmed)
+	if err != nil {
+		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("failed to get confirmed subscription count: %w", err), nil)
+	}
+
+	pending, err := Store.GetSubscriptionCount(ctx, db.SubscriptionStatusPending)
+	if err != nil {
+		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("failed to get pending subscription count: %w", err), nil)
+	}
+
+	response, err := xlambda.ProxyResponseJSON(http.StatusOK, nil, &ResponseData{
+		Confirmed: nonNegative(confirmed),
+		Pending:   nonNegative(pending),
+	})
+	if err != nil {
+		return response, err
+	}
+	response.Headers["Cache-Control"] = fmt.Sprintf("public, max-age=%d", CacheMaxAge)
+
+	return response, nil
+}
+
+type ResponseData struct {
+	Confirmed int64 `json:"confirmed"`
+	Pending   int64 `json:"pending"`
+}
+
+// nonNegative clamps a count to zero. The counts can briefly drift below zero when
+// items expire before the count is reconciled.
+func nonNegative(count int64) int64 {
+	if count < 0 {
+		return 0
+	}
+
+	return count
+}
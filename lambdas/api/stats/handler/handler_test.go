@@ -0,0 +1,31 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/api/stats/handler"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	handler.Store = db.NewMemoryStore()
+
+	confirmed := db.NewSubscription("confirmed-id", "confirmed@example.com")
+	require.NoError(t, handler.Store.CreateSubscription(ctx, confirmed))
+	confirmed.Confirm()
+	require.NoError(t, handler.Store.UpdateSubscription(ctx, confirmed))
+	require.NoError(t, handler.Store.CreateSubscription(ctx, db.NewSubscription("pending-id", "pending@example.com")))
+	require.NoError(t, handler.Store.CreateSubscription(ctx, db.NewSubscription("other-id", "other@example.com")))
+
+	response, err := handler.Handler(ctx, &events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+	require.JSONEq(t, `{"confirmed": 1, "pending": 2}`, response.Body)
+	require.Equal(t, "public, max-age=300", response.Headers["Cache-Control"])
+}
@@ -8,15 +8,23 @@ import (
 	"net/mail"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
 	"github.com/gofor-little/xlambda"
 	"github.com/gofor-little/xrand"
 
+	"github.com/strongishllama/millhouse.dev-cdk/internal/captcha"
 	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
-	"github.com/strongishllama/millhouse.dev-cdk/internal/recaptcha"
 )
 
 var (
-	RecaptchaSecret string
+	// Verifier verifies the captcha challenge completed by the reader.
+	Verifier captcha.Verifier
+	// Policy decides whether a verified request is accepted, held for review or rejected.
+	Policy = captcha.DefaultPolicy
+	Store  interface {
+		db.SubscriptionStore
+		db.AlertStore
+	}
 )
 
 func Handler(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
@@ -25,40 +33,117 @@ func Handler(ctx context.Context, request *events.APIGatewayProxyRequest) (*even
 		return xlambda.ProxyResponseJSON(http.StatusBadRequest, err, nil)
 	}
 
-	score, err := recaptcha.Verify(ctx, RecaptchaSecret, data.ReCaptchaChallengeToken)
+	result, err := Verifier.Verify(ctx, data.ReCaptchaChallengeToken)
 	if err != nil {
-		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("recaptcha verification failed: %w", err), nil)
+		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("captcha verification failed: %w", err), nil)
 	}
-	if score <= 0.5 {
+	decision := Policy.Decide(result)
+	if decision == captcha.DecisionReject {
+		// The owner is told about rejected requests in their digest. The reader isn't
+		// told so bots don't learn anything.
+		log.Info(log.Fields{
+			"message":  "captcha challenge rejected",
+			"success":  result.Success,
+			"score":    result.Score,
+			"reasons":  result.Reasons,
+			"hostname": result.Hostname,
+			"action":   result.Action,
+		})
+		alert := db.NewAlert(db.AlertKindRecaptchaChallengeFailed, data.EmailAddress)
+		alert.Score = result.Score
+		if err := Store.CreateAlert(ctx, alert); err != nil {
+			log.Error(log.Fields{"error": fmt.Errorf("failed to create recaptcha challenge failed alert: %w", err)})
+		}
+
 		return xlambda.ProxyResponseJSON(http.StatusOK, nil, nil)
 	}
 
-	subscription, err := db.GetSubscription(ctx, data.EmailAddress)
+	subscription, err := createSubscription(ctx, data.EmailAddress, decision)
 	if err != nil {
-		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("failed to check if subscription already exists: %w", err), nil)
+		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, err, nil)
 	}
-	if subscription != nil {
+	if subscription == nil {
+		// The reader already has a subscription.
 		return xlambda.ProxyResponseJSON(http.StatusOK, nil, nil)
 	}
 
-	id, err := xrand.UUIDV4()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate UUID: %w", err)
-	}
-	subscription = &db.Subscription{
-		ID:           id,
-		EmailAddress: data.EmailAddress,
-		IsConfirmed:  false,
-	}
-	if err := subscription.Create(ctx); err != nil {
-		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("failed to create subscription: %w", err), nil)
+	if subscription.Status == db.SubscriptionStatusHeld {
+		// The owner reviews held subscriptions from their digest. The reader sees the
+		// same response as an accepted request.
+		log.Info(log.Fields{
+			"message":        "subscription held for review",
+			"subscriptionId": subscription.ID,
+			"score":          result.Score,
+		})
+		alert := db.NewAlert(db.AlertKindSubscriptionHeld, data.EmailAddress)
+		alert.Score = result.Score
+		if err := Store.CreateAlert(ctx, alert); err != nil {
+			log.Error(log.Fields{"error": fmt.Errorf("failed to create subscription held alert: %w", err)})
+		}
 	}
 
 	return xlambda.ProxyResponseJSON(http.StatusOK, nil, nil)
 }
 
+// createSubscription creates a subscription for the email address unless it already
+// has one that hasn't expired, in which case nil is returned. If a concurrent request
+// creates one first, it's read again so the outcome is the same as if it had finished
+// before this one.
+func createSubscription(ctx context.Context, emailAddress string, decision captcha.Decision) (*db.Subscription, error) {
+	for attempt := 0; ; attempt++ {
+		subscription, err := Store.GetSubscription(ctx, emailAddress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check if subscription already exists: %w", err)
+		}
+		if subscription != nil && !subscription.IsExpired() {
+			return nil, nil
+		}
+
+		// DynamoDB can take a while to delete expired subscriptions, delete it now so the
+		// reader can subscribe again straight away.
+		if subscription != nil {
+			if err := Store.DeleteSubscription(ctx, subscription.EmailAddress, subscription.ID); err != nil && !errors.Is(err, db.ErrNotFound) {
+				return nil, fmt.Errorf("failed to delete expired subscription: %w", err)
+			}
+		}
+
+		id, err := xrand.UUIDV4()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate UUID: %w", err)
+		}
+		subscription = db.NewSubscription(id, emailAddress)
+
+		outbox := []*db.OutboxEntry{}
+		if decision == captcha.DecisionHold {
+			// Held subscriptions aren't sent a confirmation email until they're approved.
+			subscription.Hold()
+		} else {
+			// The confirmation email is sent from the outbox, which is written in the same
+			// transaction as the subscription.
+			entryID, err := xrand.UUIDV4()
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate UUID: %w", err)
+			}
+			outbox = append(outbox, db.NewOutboxEntry(entryID, db.OutboxKindSubscriptionConfirmation, subscription))
+		}
+
+		err = Store.CreateSubscription(ctx, subscription, outbox...)
+		if errors.Is(err, db.ErrAlreadyExists) && attempt == 0 {
+			// Another subscription for the email address was created since it was read.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create subscription: %w", err)
+		}
+
+		return subscription, nil
+	}
+}
+
 type RequestData struct {
-	EmailAddress            string `json:"emailAddress"`
+	EmailAddress string `json:"emailAddress"`
+	// ReCaptchaChallengeToken is the response token of the configured captcha provider.
+	// It keeps its name for the website's existing requests.
 	ReCaptchaChallengeToken string `json:"recaptchaChallengeToken"`
 }
 
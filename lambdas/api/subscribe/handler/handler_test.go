@@ -0,0 +1,129 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/gofor-little/log"
+	"github.com/gofor-little/xlambda"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/captcha"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/xhttp"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/api/subscribe/handler"
+)
+
+func TestHandler(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	handler.Store = db.NewMemoryStore()
+	handler.Verifier = &captcha.RecaptchaV3Verifier{HTTPClient: newFakeClient(0.9)}
+
+	request, err := xlambda.ProxyRequest(http.MethodPut, nil, &handler.RequestData{
+		EmailAddress:            "test@example.com",
+		ReCaptchaChallengeToken: "token",
+	})
+	require.NoError(t, err)
+
+	response, err := handler.Handler(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	subscription, err := handler.Store.GetSubscription(context.Background(), "test@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, subscription)
+	require.Equal(t, db.SubscriptionStatusPending, subscription.Status)
+
+	// Subscribing again with the same email address doesn't create another subscription.
+	response, err = handler.Handler(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	subscriptions, err := handler.Store.GetSubscriptions(context.Background())
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+}
+
+func TestHandlerLowScore(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	handler.Store = db.NewMemoryStore()
+	handler.Verifier = &captcha.RecaptchaV3Verifier{HTTPClient: newFakeClient(0.1)}
+
+	request, err := xlambda.ProxyRequest(http.MethodPut, nil, &handler.RequestData{
+		EmailAddress:            "test@example.com",
+		ReCaptchaChallengeToken: "token",
+	})
+	require.NoError(t, err)
+
+	response, err := handler.Handler(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	subscription, err := handler.Store.GetSubscription(context.Background(), "test@example.com")
+	require.NoError(t, err)
+	require.Nil(t, subscription)
+
+	alerts, err := handler.Store.GetAlerts(context.Background())
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, db.AlertKindRecaptchaChallengeFailed, alerts[0].Kind)
+	require.Equal(t, "test@example.com", alerts[0].EmailAddress)
+	require.InDelta(t, 0.1, alerts[0].Score, 0.001)
+}
+
+func TestHandlerHeldScore(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	store := db.NewMemoryStore()
+	handler.Store = store
+	handler.Verifier = &captcha.RecaptchaV3Verifier{HTTPClient: newFakeClient(0.4)}
+
+	request, err := xlambda.ProxyRequest(http.MethodPut, nil, &handler.RequestData{
+		EmailAddress:            "test@example.com",
+		ReCaptchaChallengeToken: "token",
+	})
+	require.NoError(t, err)
+
+	response, err := handler.Handler(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, response.StatusCode)
+
+	// Held subscriptions are stored without a confirmation email.
+	subscription, err := store.GetSubscription(context.Background(), "test@example.com")
+	require.NoError(t, err)
+	require.NotNil(t, subscription)
+	require.Equal(t, db.SubscriptionStatusHeld, subscription.Status)
+
+	entries, err := store.GetPendingOutboxEntries(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	alerts, err := store.GetAlerts(context.Background())
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, db.AlertKindSubscriptionHeld, alerts[0].Kind)
+	require.InDelta(t, 0.4, alerts[0].Score, 0.001)
+
+	// Approving the subscription sends the confirmation email.
+	subscription.Approve()
+	require.NoError(t, store.UpdateSubscription(context.Background(), subscription, db.NewOutboxEntry("entry-id", db.OutboxKindSubscriptionConfirmation, subscription)))
+
+	count, err := store.GetSubscriptionCount(context.Background(), db.SubscriptionStatusPending)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), count)
+
+	entries, err = store.GetPendingOutboxEntries(context.Background())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+}
+
+// newFakeClient returns a client that responds to every siteverify request with a
+// successful challenge with the score.
+func newFakeClient(score float32) *xhttp.FakeClient {
+	client := xhttp.NewFakeClient()
+	client.On(http.MethodPost, "https://www.google.com/recaptcha/api/siteverify").
+		RespondJSON(http.StatusOK, &captcha.ResponseData{Score: score, Success: true})
+
+	return client
+}
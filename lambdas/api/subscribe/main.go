@@ -11,6 +11,7 @@ import (
 	"github.com/gofor-little/log"
 	"github.com/gofor-little/xlambda"
 
+	"github.com/strongishllama/millhouse.dev-cdk/internal/captcha"
 	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
 	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
 	"github.com/strongishllama/millhouse.dev-cdk/lambdas/api/subscribe/handler"
@@ -19,12 +20,20 @@ import (
 func main() {
 	log.Log = log.NewStandardLogger(os.Stdout, nil)
 
-	if err := db.Initialize(context.Background(), "", "", env.Get("TABLE_NAME", "")); err != nil {
-		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the db package: %w", err)})
+	var err error
+	handler.Store, err = db.NewDynamoDBStore(context.Background(), "", "", env.Get("TABLE_NAME", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the subscription store: %w", err)})
 		os.Exit(1)
 	}
 
-	if err := notification.Initialize(context.Background(), "", "", env.Get("EMAIL_QUEUE_URL", "")); err != nil {
+	notificationConfig, err := notification.ConfigFromEnv()
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load the notification config: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := notification.Initialize(context.Background(), notificationConfig); err != nil {
 		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the notifications package: %w", err)})
 		os.Exit(1)
 	}
@@ -39,10 +48,21 @@ func main() {
 		os.Exit(1)
 	}
 
-	var err error
-	handler.RecaptchaSecret, err = cfg.LoadString(context.Background(), env.Get("RECAPTCHA_SECRET_ARN", ""))
+	captchaConfig, err := captcha.ConfigFromEnv()
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load the captcha config: %w", err)})
+		os.Exit(1)
+	}
+	handler.Policy = captchaConfig.Policy
+	captchaConfig.Secret, err = cfg.LoadString(context.Background(), env.Get("CAPTCHA_SECRET_ARN", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load captcha secret: %w", err)})
+		os.Exit(1)
+	}
+
+	handler.Verifier, err = captcha.NewVerifier(captchaConfig)
 	if err != nil {
-		log.Error(log.Fields{"error": fmt.Errorf("failed to load recaptcha secret: %w", err)})
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the captcha verifier: %w", err)})
 		os.Exit(1)
 	}
 
@@ -2,28 +2,36 @@ package handler
 
 import (
 	"context"
-	"embed"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
-	"net/mail"
+	"net/url"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/gofor-little/xlambda"
+	"github.com/gofor-little/xrand"
 
 	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
-	"github.com/strongishllama/millhouse.dev-cdk/internal/tmpl"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/page"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
 )
 
-var (
-	//go:embed templates
-	templates embed.FS
-)
+var Store db.SubscriptionStore
 
 func Handler(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
-	template, err := tmpl.NewTemplateFromFile(templates, "templates/unsubscribe-successful.tmpl.html", nil)
+	if request.HTTPMethod == http.MethodPost {
+		return oneClick(ctx, request)
+	}
+
+	template, err := page.Render("unsubscribe-successful.tmpl.html", nil)
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to render page: %w", err), nil)
+	}
+
+	alreadyTemplate, err := page.Render("unsubscribe-already.tmpl.html", nil)
 	if err != nil {
-		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to create template from file: %w", err), nil)
+		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to render page: %w", err), nil)
 	}
 
 	data := &RequestData{}
@@ -31,24 +39,103 @@ func Handler(ctx context.Context, request *events.APIGatewayProxyRequest) (*even
 		return xlambda.ProxyResponseHTML(http.StatusBadRequest, err, nil)
 	}
 
-	if err := db.DeleteSubscription(ctx, data.EmailAddress, data.ID); err != nil {
+	claims, err := token.Parse(data.Token, token.ActionUnsubscribe)
+	if err != nil {
+		return xlambda.ProxyResponseHTML(http.StatusBadRequest, fmt.Errorf("failed to parse token: %w", err), nil)
+	}
+
+	err = unsubscribe(ctx, claims)
+	if errors.Is(err, db.ErrNotFound) {
+		return xlambda.ProxyResponseHTML(http.StatusOK, nil, alreadyTemplate)
+	}
+	if err != nil {
 		return xlambda.ProxyResponseHTML(http.StatusInternalServerError, fmt.Errorf("failed to delete subscription: %w", err), template)
 	}
 
 	return xlambda.ProxyResponseHTML(http.StatusOK, nil, template)
 }
 
+// oneClick handles the RFC 8058 one-click unsubscribe POST that mailbox providers send
+// to the URL in the List-Unsubscribe header. There is no reader to show a page to, so
+// only the status code matters.
+func oneClick(ctx context.Context, request *events.APIGatewayProxyRequest) (*events.APIGatewayProxyResponse, error) {
+	data := &RequestData{}
+	if err := xlambda.ParseAndValidate(request, data); err != nil {
+		return xlambda.ProxyResponseJSON(http.StatusBadRequest, err, nil)
+	}
+
+	if err := validateOneClickBody(request); err != nil {
+		return xlambda.ProxyResponseJSON(http.StatusBadRequest, err, nil)
+	}
+
+	claims, err := token.Parse(data.Token, token.ActionUnsubscribe)
+	if err != nil {
+		return xlambda.ProxyResponseJSON(http.StatusBadRequest, fmt.Errorf("failed to parse token: %w", err), nil)
+	}
+
+	err = unsubscribe(ctx, claims)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return xlambda.ProxyResponseJSON(http.StatusInternalServerError, fmt.Errorf("failed to delete subscription: %w", err), nil)
+	}
+
+	return xlambda.ProxyResponseJSON(http.StatusOK, nil, nil)
+}
+
+// unsubscribe deletes the subscription the token was issued for. Confirmed readers are
+// sent the goodbye email from the outbox, which is written in the same transaction as
+// the delete. If the subscription doesn't exist ErrNotFound is returned.
+func unsubscribe(ctx context.Context, claims *token.Claims) error {
+	subscription, err := Store.GetSubscription(ctx, claims.EmailAddress)
+	if err != nil {
+		return err
+	}
+	if subscription == nil || subscription.ID != claims.SubscriptionID {
+		return db.ErrNotFound
+	}
+
+	outbox := []*db.OutboxEntry{}
+	if subscription.Status == db.SubscriptionStatusConfirmed {
+		entryID, err := xrand.UUIDV4()
+		if err != nil {
+			return fmt.Errorf("failed to generate UUID: %w", err)
+		}
+		outbox = append(outbox, db.NewOutboxEntry(entryID, db.OutboxKindGoodbye, subscription))
+	}
+
+	return Store.DeleteSubscription(ctx, subscription.EmailAddress, subscription.ID, outbox...)
+}
+
+// validateOneClickBody checks the request body is the List-Unsubscribe=One-Click form
+// RFC 8058 requires, so other POSTs to the URL don't unsubscribe the reader.
+func validateOneClickBody(request *events.APIGatewayProxyRequest) error {
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return fmt.Errorf("failed to decode request body: %w", err)
+		}
+		body = string(decoded)
+	}
+
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return fmt.Errorf("failed to parse request body: %w", err)
+	}
+
+	if values.Get("List-Unsubscribe") != "One-Click" {
+		return errors.New("request body must be List-Unsubscribe=One-Click")
+	}
+
+	return nil
+}
+
 type RequestData struct {
-	ID           string `mapstructure:"id"`
-	EmailAddress string `mapstructure:"emailAddress"`
+	Token string `mapstructure:"token"`
 }
 
 func (r *RequestData) Validate() error {
-	if len(r.ID) == 0 {
-		return errors.New("id cannot be empty")
-	}
-	if _, err := mail.ParseAddress(r.EmailAddress); err != nil {
-		return fmt.Errorf("failed to validate EmailAddress: %w", err)
+	if len(r.Token) == 0 {
+		return errors.New("token cannot be empty")
 	}
 	return nil
 }
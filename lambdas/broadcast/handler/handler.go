@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+)
+
+// pageSize is the number of subscriptions fetched at a time while broadcasting.
+const pageSize = 100
+
+var (
+	Store interface {
+		db.SubscriptionStore
+		db.DeliveryStore
+	}
+	FromAddress   string
+	APIDomain     string
+	WebsiteDomain string
+)
+
+// Handler emails a new post to every confirmed subscription. It can be invoked directly
+// or by an EventBridge rule whose target input is the post. Each subscription's delivery
+// is recorded after its email is enqueued so invoking it again with the same post only
+// sends to the subscriptions that haven't received it yet.
+func Handler(ctx context.Context, post *Post) (*Result, error) {
+	if err := post.validate(); err != nil {
+		return nil, fmt.Errorf("invalid post: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result := &Result{
+		BroadcastID: post.broadcastID(),
+	}
+
+	subscriptions, errs := Store.StreamSubscriptions(ctx, pageSize)
+	for subscription := range subscriptions {
+		if subscription.Status != db.SubscriptionStatusConfirmed {
+			continue
+		}
+
+		sent, err := deliver(ctx, result.BroadcastID, post, subscription)
+		if err != nil {
+			return nil, err
+		}
+
+		if sent {
+			result.Sent++
+		} else {
+			result.Skipped++
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, fmt.Errorf("failed to stream subscriptions: %w", err)
+	}
+
+	log.Info(log.Fields{"message": "broadcast post", "broadcastId": result.BroadcastID, "sent": result.Sent, "skipped": result.Skipped})
+
+	return result, nil
+}
+
+// deliver enqueues the post's email for the subscription unless it has already been
+// delivered, and reports whether it was sent. The delivery is only recorded once the
+// email is enqueued so a failed enqueue is retried with the broadcast. If the broadcast
+// fails between the two, the email ledger stops the retry sending it again.
+func deliver(ctx context.Context, broadcastID string, post *Post, subscription *db.Subscription) (bool, error) {
+	delivery, err := Store.GetDelivery(ctx, broadcastID, subscription.ID)
+	if err != nil {
+		return false, err
+	}
+	if delivery != nil {
+		return false, nil
+	}
+
+	if err := enqueue(ctx, broadcastID, post, subscription); err != nil {
+		return false, err
+	}
+
+	// A concurrent invocation may have delivered it first, the ledger only let one of
+	// them send the email.
+	if err := Store.CreateDelivery(ctx, db.NewDelivery(broadcastID, subscription)); err != nil && !errors.Is(err, db.ErrAlreadyExists) {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func enqueue(ctx context.Context, broadcastID string, post *Post, subscription *db.Subscription) error {
+	unsubscribeToken, err := token.Issue(token.ActionUnsubscribe, subscription.ID, subscription.EmailAddress, token.UnsubscribeTTL)
+	if err != nil {
+		return fmt.Errorf("failed to issue unsubscribe token: %w", err)
+	}
+
+	_, err = notification.EnqueueEmail(ctx, []string{subscription.EmailAddress}, FromAddress, notification.EmailTemplate{
+		FileName: "email/new-post.tmpl.html",
+		Subject:  fmt.Sprintf("New Post: %s", post.Title),
+		// The key is claimed in the email ledger so the post isn't sent twice if the
+		// delivery couldn't be recorded.
+		IdempotencyKey: fmt.Sprintf("%s#%s", broadcastID, subscription.ID),
+		UnsubscribeURL: fmt.Sprintf("https://%s/unsubscribe?token=%s", APIDomain, unsubscribeToken),
+		Data: notification.NewPostTemplateData{
+			WebsiteDomain:    WebsiteDomain,
+			APIDomain:        APIDomain,
+			Title:            post.Title,
+			URL:              post.URL,
+			Summary:          post.Summary,
+			UnsubscribeToken: unsubscribeToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue email: %w", err)
+	}
+
+	return nil
+}
+
+// Post is the post to broadcast.
+type Post struct {
+	// ID identifies the broadcast. It defaults to a hash of the URL so the same post is
+	// only broadcast once. Set it to broadcast a post again.
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Summary string `json:"summary"`
+}
+
+func (p *Post) broadcastID() string {
+	if p.ID != "" {
+		return p.ID
+	}
+
+	hash := sha256.Sum256([]byte(p.URL))
+	return hex.EncodeToString(hash[:])
+}
+
+func (p *Post) validate() error {
+	if p == nil {
+		return errors.New("post cannot be nil")
+	}
+
+	if len(p.Title) == 0 {
+		return errors.New("title cannot be empty")
+	}
+
+	if len(p.URL) == 0 {
+		return errors.New("url cannot be empty")
+	}
+
+	return nil
+}
+
+// Result reports the progress of a broadcast.
+type Result struct {
+	BroadcastID string `json:"broadcastId"`
+	// Sent is the number of emails enqueued by this invocation.
+	Sent int `json:"sent"`
+	// Skipped is the number of subscriptions that had already been sent the post.
+	Skipped int `json:"skipped"`
+}
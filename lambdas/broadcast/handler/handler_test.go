@@ -0,0 +1,99 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gofor-little/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/broadcast/handler"
+)
+
+type failingSender struct{}
+
+func (failingSender) Send(ctx context.Context, message *notification.Message) (string, error) {
+	return "", errors.New("failed to send")
+}
+
+func TestHandler(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	ctx := context.Background()
+
+	require.NoError(t, token.Initialize("test-secret"))
+	path := filepath.Join(t.TempDir(), "mbox")
+	require.NoError(t, notification.Initialize(ctx, notification.Config{Backend: notification.BackendFile, FilePath: path}))
+	sender := notification.EmailSender
+
+	store := db.NewMemoryStore()
+	handler.Store = store
+	handler.FromAddress = "from@example.com"
+	handler.APIDomain = "api.example.com"
+	handler.WebsiteDomain = "example.com"
+	notification.EmailLedger = store
+	defer func() { notification.EmailLedger = nil }()
+
+	for _, id := range []string{"1", "2"} {
+		subscription := db.NewSubscription(id, id+"@example.com")
+		subscription.Confirm()
+		require.NoError(t, store.CreateSubscription(ctx, subscription))
+	}
+	// Pending subscriptions aren't sent the post.
+	require.NoError(t, store.CreateSubscription(ctx, db.NewSubscription("3", "3@example.com")))
+
+	post := &handler.Post{ID: "post", Title: "Title", URL: "https://example.com/post"}
+	sent := func(emailAddress string) int {
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return strings.Count(string(data), "To: "+emailAddress)
+	}
+
+	// Nothing is recorded if the email can't be enqueued.
+	notification.EmailSender = failingSender{}
+	_, err := handler.Handler(ctx, post)
+	require.Error(t, err)
+	notification.EmailSender = sender
+	delivery, err := store.GetDelivery(ctx, post.ID, "1")
+	require.NoError(t, err)
+	require.Nil(t, delivery)
+
+	result, err := handler.Handler(ctx, post)
+	require.NoError(t, err)
+	require.Equal(t, 2, result.Sent)
+	require.Equal(t, 0, result.Skipped)
+	require.Equal(t, 1, sent("1@example.com"))
+	require.Equal(t, 1, sent("2@example.com"))
+	require.Equal(t, 0, sent("3@example.com"))
+
+	count, err := store.GetDeliveryCount(ctx, result.BroadcastID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), count)
+
+	// Broadcasting it again resumes with the subscriptions that haven't been sent it.
+	subscription := db.NewSubscription("4", "4@example.com")
+	subscription.Confirm()
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+
+	result, err = handler.Handler(ctx, post)
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Sent)
+	require.Equal(t, 2, result.Skipped)
+	require.Equal(t, 1, sent("1@example.com"))
+	require.Equal(t, 1, sent("4@example.com"))
+
+	// An email that was enqueued but whose delivery wasn't recorded isn't sent again.
+	require.NoError(t, store.DeleteDelivery(ctx, result.BroadcastID, "1"))
+	_, err = handler.Handler(ctx, post)
+	require.NoError(t, err)
+	require.Equal(t, 1, sent("1@example.com"))
+	delivery, err = store.GetDelivery(ctx, result.BroadcastID, "1")
+	require.NoError(t, err)
+	require.NotNil(t, delivery)
+}
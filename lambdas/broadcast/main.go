@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gofor-little/cfg"
+	"github.com/gofor-little/env"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/broadcast/handler"
+)
+
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	store, err := db.NewDynamoDBStore(context.Background(), "", "", env.Get("TABLE_NAME", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the subscription store: %w", err)})
+		os.Exit(1)
+	}
+	handler.Store = store
+	// Emails are claimed in the ledger before they're sent so a broadcast retried before
+	// it recorded a delivery doesn't send the post twice.
+	notification.EmailLedger = store
+
+	notificationConfig, err := notification.ConfigFromEnv()
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load the notification config: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := notification.Initialize(context.Background(), notificationConfig); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the notification package: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := cfg.Initialize(context.Background(), "", ""); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the cfg package: %w", err)})
+		os.Exit(1)
+	}
+
+	tokenSecret, err := cfg.LoadString(context.Background(), env.Get("TOKEN_SECRET_ARN", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load token secret: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := token.Initialize(tokenSecret); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the token package: %w", err)})
+		os.Exit(1)
+	}
+
+	handler.FromAddress, err = env.MustGet("FROM_ADDRESS")
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+	handler.APIDomain, err = env.MustGet("API_DOMAIN")
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+	handler.WebsiteDomain, err = env.MustGet("WEBSITE_DOMAIN")
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+
+	lambda.Start(handler.Handler)
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+)
+
+// maxListedAlerts is the number of alerts of each kind listed in a digest. The rest are
+// only counted so a flood of rejected requests doesn't make the digest unreadable.
+const maxListedAlerts = 50
+
+var (
+	Store         db.AlertStore
+	FromAddress   string
+	OwnerAddress  string
+	WebsiteDomain string
+)
+
+// Handler emails the site owner a digest of the alerts raised since the last digest
+// and then deletes them. It is run on a daily schedule. If the digest can't be sent
+// the alerts are kept and included in the next one. The digest's idempotency key is
+// the day of the scheduled event, so a retried run doesn't send it twice.
+func Handler(ctx context.Context, event *events.CloudWatchEvent) error {
+	alerts, err := Store.GetAlerts(ctx)
+	if err != nil {
+		return err
+	}
+	if len(alerts) == 0 {
+		return nil
+	}
+
+	data := notification.OwnerDigestTemplateData{
+		WebsiteDomain: WebsiteDomain,
+	}
+	listed := map[db.AlertKind]int{}
+	for _, alert := range alerts {
+		if listed[alert.Kind] == maxListedAlerts {
+			data.Unlisted++
+			continue
+		}
+		listed[alert.Kind]++
+
+		switch alert.Kind {
+		case db.AlertKindReaderUnsubscribed:
+			data.ReadersUnsubscribed = append(data.ReadersUnsubscribed, notification.ReaderUnsubscribedTemplateData{
+				EmailAddress: alert.EmailAddress,
+			})
+		case db.AlertKindRecaptchaChallengeFailed:
+			data.RecaptchaChallengesFailed = append(data.RecaptchaChallengesFailed, notification.RecaptchaChallengeFailedTemplateData{
+				EmailAddress: alert.EmailAddress,
+				Score:        alert.Score,
+			})
+		case db.AlertKindSubscriptionHeld:
+			data.SubscriptionsHeld = append(data.SubscriptionsHeld, notification.SubscriptionHeldTemplateData{
+				EmailAddress: alert.EmailAddress,
+				Score:        alert.Score,
+			})
+		}
+	}
+
+	day := time.Now()
+	if event != nil && !event.Time.IsZero() {
+		day = event.Time
+	}
+
+	_, err = notification.EnqueueEmail(ctx, []string{OwnerAddress}, FromAddress, notification.EmailTemplate{
+		FileName:       "email/owner-digest.tmpl.html",
+		Subject:        fmt.Sprintf("%s Digest", WebsiteDomain),
+		Data:           data,
+		IdempotencyKey: fmt.Sprintf("digest#%s", day.UTC().Format("2006-01-02")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enqueue digest: %w", err)
+	}
+
+	for _, alert := range alerts {
+		if err := Store.DeleteAlert(ctx, alert); err != nil && !errors.Is(err, db.ErrNotFound) {
+			return err
+		}
+	}
+
+	log.Info(log.Fields{"message": "sent digest", "alerts": len(alerts)})
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/digest/handler"
+)
+
+func TestHandler(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "mbox")
+	require.NoError(t, notification.Initialize(ctx, notification.Config{Backend: notification.BackendFile, FilePath: path}))
+
+	store := db.NewMemoryStore()
+	handler.Store = store
+	notification.EmailLedger = store
+	t.Cleanup(func() {
+		notification.EmailLedger = nil
+	})
+	handler.OwnerAddress = "owner@example.com"
+	handler.FromAddress = "from@example.com"
+	handler.WebsiteDomain = "example.com"
+
+	// Nothing is sent when there are no alerts.
+	require.NoError(t, handler.Handler(ctx, nil))
+	require.NoFileExists(t, path)
+
+	require.NoError(t, store.CreateAlert(ctx, db.NewAlert(db.AlertKindReaderUnsubscribed, "reader@example.com")))
+	alert := db.NewAlert(db.AlertKindRecaptchaChallengeFailed, "bot@example.com")
+	alert.Score = 0.1
+	require.NoError(t, store.CreateAlert(ctx, alert))
+	// Repeated alerts about the same email address are only recorded once.
+	require.NoError(t, store.CreateAlert(ctx, db.NewAlert(db.AlertKindRecaptchaChallengeFailed, "bot@example.com")))
+	// Only the first alerts of each kind are listed.
+	for i := 0; i < 60; i++ {
+		require.NoError(t, store.CreateAlert(ctx, db.NewAlert(db.AlertKindSubscriptionHeld, fmt.Sprintf("held-%d@example.com", i))))
+	}
+	alerts, err := store.GetAlerts(ctx)
+	require.NoError(t, err)
+	require.Len(t, alerts, 62)
+
+	event := &events.CloudWatchEvent{Time: time.Date(2021, 6, 12, 0, 0, 0, 0, time.UTC)}
+	require.NoError(t, handler.Handler(ctx, event))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "To: owner@example.com")
+	require.Contains(t, string(data), "reader@example.com")
+	require.Contains(t, string(data), "bot@example.com scored 0.10")
+	require.Contains(t, string(data), "held-49@example.com")
+	require.NotContains(t, string(data), "held-50@example.com")
+	require.Contains(t, string(data), "10 more alerts weren't listed.")
+
+	alerts, err = store.GetAlerts(ctx)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+
+	// A retried run whose digest was sent but whose alerts weren't deleted doesn't send
+	// the digest again.
+	require.NoError(t, store.CreateAlert(ctx, db.NewAlert(db.AlertKindReaderUnsubscribed, "reader@example.com")))
+	require.NoError(t, handler.Handler(ctx, event))
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "To: owner@example.com"))
+
+	alerts, err = store.GetAlerts(ctx)
+	require.NoError(t, err)
+	require.Empty(t, alerts)
+}
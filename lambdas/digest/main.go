@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gofor-little/env"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/digest/handler"
+)
+
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	store, err := db.NewDynamoDBStore(context.Background(), "", "", env.Get("TABLE_NAME", ""))
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the alert store: %w", err)})
+		os.Exit(1)
+	}
+	handler.Store = store
+	// The digest is claimed in the ledger before it's sent so a retried run doesn't send
+	// the day's digest twice.
+	notification.EmailLedger = store
+
+	notificationConfig, err := notification.ConfigFromEnv()
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load the notification config: %w", err)})
+		os.Exit(1)
+	}
+
+	if err := notification.Initialize(context.Background(), notificationConfig); err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to initialize the notification package: %w", err)})
+		os.Exit(1)
+	}
+
+	handler.FromAddress, err = env.MustGet("FROM_ADDRESS")
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+	handler.OwnerAddress, err = env.MustGet("OWNER_ADDRESS")
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+	handler.WebsiteDomain, err = env.MustGet("WEBSITE_DOMAIN")
+	if err != nil {
+		log.Error(log.Fields{"error": err})
+		os.Exit(1)
+	}
+
+	lambda.Start(handler.Handler)
+}
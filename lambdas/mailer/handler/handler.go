@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+)
+
+var (
+	Sender notification.Sender
+)
+
+// Handler sends the emails enqueued by notification.SQSSender. If an email can't be
+// sent, its message and the ones after it are reported as the batch's failures so SQS
+// only redelivers those and the emails already sent aren't sent again.
+func Handler(ctx context.Context, event *events.SQSEvent) (*events.SQSEventResponse, error) {
+	response := &events.SQSEventResponse{
+		BatchItemFailures: []events.SQSBatchItemFailure{},
+	}
+
+	for i, r := range event.Records {
+		if err := send(ctx, r); err != nil {
+			log.Error(log.Fields{"error": err, "sqsMessageId": r.MessageId})
+			for _, failed := range event.Records[i:] {
+				response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+					ItemIdentifier: failed.MessageId,
+				})
+			}
+			break
+		}
+	}
+
+	return response, nil
+}
+
+func send(ctx context.Context, r events.SQSMessage) error {
+	message := &notification.Message{}
+	if err := json.Unmarshal([]byte(r.Body), message); err != nil {
+		return fmt.Errorf("failed to unmarshal SQS message %s into notification.Message: %w", r.MessageId, err)
+	}
+
+	id, err := Sender.Send(ctx, message)
+	if err != nil {
+		return fmt.Errorf("failed to send SQS message %s: %w", r.MessageId, err)
+	}
+
+	log.Info(log.Fields{"message": "sent email", "sqsMessageId": r.MessageId, "emailMessageId": id})
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package handler_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/mailer/handler"
+)
+
+func TestHandler(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	path := filepath.Join(t.TempDir(), "mbox")
+	var err error
+	handler.Sender, err = notification.NewFileSender(path)
+	require.NoError(t, err)
+
+	response, err := handler.Handler(context.Background(), &events.SQSEvent{
+		Records: []events.SQSMessage{
+			{
+				MessageId: "message-id",
+				Body:      `{"to": ["reader@example.com"], "from": "author@example.com", "subject": "Subject", "htmlBody": "<p>Body</p>", "textBody": "Body", "headers": {"List-Unsubscribe": "<https://example.com/unsubscribe>"}}`,
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "List-Unsubscribe: <https://example.com/unsubscribe>")
+	require.Contains(t, string(data), "<p>Body</p>")
+
+	// The failed message and the ones after it are redelivered, the ones before it
+	// aren't.
+	response, err = handler.Handler(context.Background(), &events.SQSEvent{
+		Records: []events.SQSMessage{
+			{MessageId: "sent", Body: `{"to": ["reader@example.com"], "from": "author@example.com", "subject": "Subject", "htmlBody": "<p>Body</p>", "textBody": "Body"}`},
+			{MessageId: "invalid", Body: "{"},
+			{MessageId: "remaining", Body: `{"to": ["reader@example.com"], "from": "author@example.com", "subject": "Subject", "htmlBody": "<p>Body</p>", "textBody": "Body"}`},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []events.SQSBatchItemFailure{{ItemIdentifier: "invalid"}, {ItemIdentifier: "remaining"}}, response.BatchItemFailures)
+}
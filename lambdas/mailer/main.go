@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/mailer/handler"
+)
+
+func main() {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	config, err := notification.ConfigFromEnv()
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to load the notification config: %w", err)})
+		os.Exit(1)
+	}
+
+	// The mailer consumes the email queue so it can't send to it.
+	if config.Backend == notification.BackendSQS {
+		log.Error(log.Fields{"error": errors.New("EMAIL_BACKEND cannot be sqs for the mailer")})
+		os.Exit(1)
+	}
+
+	handler.Sender, err = notification.NewSender(context.Background(), config)
+	if err != nil {
+		log.Error(log.Fields{"error": fmt.Errorf("failed to create the email sender: %w", err)})
+		os.Exit(1)
+	}
+
+	lambda.Start(handler.Handler)
+}
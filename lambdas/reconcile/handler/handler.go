@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+)
+
+var (
+	Store db.SubscriptionStore
+)
+
+// Handler recomputes the subscription count item. It is run on a schedule to correct
+// any drift, such as from pending subscriptions expired by DynamoDB.
+func Handler(ctx context.Context, event *events.CloudWatchEvent) error {
+	count, err := Store.ReconcileSubscriptionCount(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile subscription count: %w", err)
+	}
+
+	log.Info(log.Fields{"message": "reconciled subscription count", "count": count})
+
+	return nil
+}
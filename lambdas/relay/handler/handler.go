@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/outbox"
+)
+
+// MinEntryAge is how old a pending outbox entry must be before it's relayed, so
+// entries the stream lambda is still publishing are left to it.
+const MinEntryAge = 15 * time.Minute
+
+var (
+	Store         db.OutboxStore
+	FromAddress   string
+	APIDomain     string
+	WebsiteDomain string
+)
+
+// Handler publishes the outbox entries the stream lambda couldn't. It is run on a
+// schedule.
+func Handler(ctx context.Context, event *events.CloudWatchEvent) error {
+	relay := &outbox.Relay{
+		Store:         Store,
+		FromAddress:   FromAddress,
+		APIDomain:     APIDomain,
+		WebsiteDomain: WebsiteDomain,
+	}
+
+	published, err := relay.PublishPending(ctx, time.Now().Add(-MinEntryAge))
+	if err != nil {
+		return fmt.Errorf("failed to relay outbox entries: %w", err)
+	}
+
+	log.Info(log.Fields{"message": "relayed outbox entries", "published": published})
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+)
+
+// RecordHandler handles a single record of the table's stream.
+type RecordHandler func(ctx context.Context, r events.DynamoDBEventRecord) error
+
+// route is the item type and event name a RecordHandler is registered for.
+type route struct {
+	itemType  db.ItemType
+	eventName string
+}
+
+// Dispatcher routes the records of the table's stream to the handlers registered for
+// their item type and event name. Records without a registered handler are skipped.
+type Dispatcher struct {
+	handlers map[route][]RecordHandler
+}
+
+// NewDispatcher returns a Dispatcher with no handlers registered.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		handlers: map[route][]RecordHandler{},
+	}
+}
+
+// Register adds a handler for the records of an item type with the given event name.
+// Handlers registered for the same item type and event name are run in the order they
+// were registered.
+func (d *Dispatcher) Register(itemType db.ItemType, eventName string, handler RecordHandler) {
+	key := route{itemType: itemType, eventName: eventName}
+	d.handlers[key] = append(d.handlers[key], handler)
+}
+
+// Dispatch runs the handlers registered for the record, stopping at the first error.
+func (d *Dispatcher) Dispatch(ctx context.Context, r events.DynamoDBEventRecord) error {
+	itemType := recordItemType(r)
+
+	for _, handler := range d.handlers[route{itemType: itemType, eventName: r.EventName}] {
+		if err := handler(ctx, r); err != nil {
+			return fmt.Errorf("failed to handle %s of %s record %s: %w", r.EventName, itemType, r.EventID, err)
+		}
+	}
+
+	return nil
+}
+
+// recordItemType returns the item type of the record's item. It's read from the
+// itemType attribute of the item's image, or the prefix of its primary key for items
+// such as count items that don't have one.
+func recordItemType(r events.DynamoDBEventRecord) db.ItemType {
+	for _, image := range []map[string]events.DynamoDBAttributeValue{r.Change.NewImage, r.Change.OldImage} {
+		if v, ok := image["itemType"]; ok && v.DataType() == events.DataTypeString {
+			return db.ItemType(v.String())
+		}
+	}
+
+	pk := r.Change.Keys["pk"]
+	if pk.DataType() != events.DataTypeString {
+		return ""
+	}
+
+	return db.ItemType(strings.SplitN(pk.String(), "#", 2)[0])
+}
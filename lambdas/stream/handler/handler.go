@@ -3,15 +3,13 @@ package handler
 import (
 	"context"
 	"fmt"
-	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
-	email "github.com/gofor-little/aws-email"
 	"github.com/gofor-little/log"
 	"github.com/gofor-little/xlambda"
 
 	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
-	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/outbox"
 )
 
 const (
@@ -21,48 +19,235 @@ const (
 )
 
 var (
+	Store interface {
+		db.SubscriptionStore
+		db.DeliveryStore
+		db.AlertStore
+		db.AuditStore
+		db.OutboxStore
+		db.SentEmailStore
+	}
 	FromAddress   string
 	APIDomain     string
 	WebsiteDomain string
+
+	// DefaultDispatcher routes the records handled by Handler. Register handlers on it to
+	// react to changes of other item types.
+	DefaultDispatcher = newDefaultDispatcher()
 )
 
-func Handler(ctx context.Context, event *events.DynamoDBEvent) error {
+// Handler dispatches each record of the batch. If a record fails, its sequence number
+// is reported as the batch's failure and the rest of the batch isn't processed. Lambda
+// retries a stream batch from the first reported failure, so the records that already
+// succeeded aren't handled again and the ones after the failure are retried with it.
+func Handler(ctx context.Context, event *events.DynamoDBEvent) (*events.DynamoDBEventResponse, error) {
+	response := &events.DynamoDBEventResponse{
+		BatchItemFailures: []events.DynamoDBBatchItemFailure{},
+	}
+
 	for _, r := range event.Records {
-		if !strings.HasPrefix(r.Change.Keys["pk"].String(), "SUBSCRIPTION#") || r.EventName != EventInsert {
-			continue
+		if err := DefaultDispatcher.Dispatch(ctx, r); err != nil {
+			log.Error(log.Fields{"error": err, "sequenceNumber": r.Change.SequenceNumber})
+			response.BatchItemFailures = append(response.BatchItemFailures, events.DynamoDBBatchItemFailure{
+				ItemIdentifier: r.Change.SequenceNumber,
+			})
+			break
 		}
+	}
 
-		var subscription *db.Subscription
-		if err := xlambda.UnmarshalDynamoDBEventAttributeValues(r.Change.NewImage, &subscription); err != nil {
-			return fmt.Errorf("failed to unmarshal DynamoDB record into db.Subscription: %w", err)
-		}
+	return response, nil
+}
 
-		if subscription.IsConfirmed {
-			continue
-		}
+func newDefaultDispatcher() *Dispatcher {
+	d := NewDispatcher()
+	d.Register(db.ItemTypeSubscription, EventInsert, auditSubscription)
+	d.Register(db.ItemTypeSubscription, EventModify, auditSubscription)
+	d.Register(db.ItemTypeSubscription, EventRemove, handleSubscriptionExpired)
+	d.Register(db.ItemTypeSubscription, EventRemove, handleSubscriptionDeleted)
+	d.Register(db.ItemTypeSubscription, EventRemove, auditSubscription)
+	d.Register(db.ItemTypeOutbox, EventInsert, handleOutboxEntryCreated)
+	d.Register(db.ItemTypeOutbox, EventRemove, handleOutboxEntryExpired)
+	d.Register(db.ItemTypeSentEmail, EventRemove, handleSentEmailExpired)
+	d.Register(db.ItemTypeDelivery, EventRemove, handleDeliveryExpired)
+	d.Register(db.ItemTypeAlert, EventRemove, handleAlertExpired)
+
+	return d
+}
+
+// handleSubscriptionExpired decrements the counts of a subscription deleted by
+// DynamoDB's time to live, as it was deleted without going through the Store.
+func handleSubscriptionExpired(ctx context.Context, r events.DynamoDBEventRecord) error {
+	if !isExpiry(r) {
+		return nil
+	}
+
+	subscription, err := unmarshalSubscription(r.Change.OldImage)
+	if err != nil {
+		return err
+	}
+
+	return Store.ExpireSubscription(ctx, subscription)
+}
+
+// handleSubscriptionDeleted records an alert for the owner's digest when a reader
+// unsubscribes. Pending subscriptions are deleted when they expire, so their removal
+// isn't an unsubscribe.
+func handleSubscriptionDeleted(ctx context.Context, r events.DynamoDBEventRecord) error {
+	if isExpiry(r) {
+		return nil
+	}
+
+	subscription, err := unmarshalSubscription(r.Change.OldImage)
+	if err != nil {
+		return err
+	}
+
+	if subscription.Status != db.SubscriptionStatusConfirmed {
+		return nil
+	}
+
+	// Alerts are deduplicated by their ID so a retried record doesn't create a second
+	// alert.
+	if err := Store.CreateAlert(ctx, db.NewAlert(db.AlertKindReaderUnsubscribed, subscription.EmailAddress)); err != nil {
+		return err
+	}
+
+	log.Info(log.Fields{"message": "reader unsubscribed", "subscriptionId": subscription.ID})
+
+	return nil
+}
+
+// handleDeliveryExpired decrements the count of a delivery deleted by DynamoDB's time
+// to live.
+func handleDeliveryExpired(ctx context.Context, r events.DynamoDBEventRecord) error {
+	if !isExpiry(r) {
+		return nil
+	}
+
+	delivery := &db.Delivery{}
+	if err := xlambda.UnmarshalDynamoDBEventAttributeValues(r.Change.OldImage, delivery); err != nil {
+		return fmt.Errorf("failed to unmarshal DynamoDB record into db.Delivery: %w", err)
+	}
+
+	return Store.ExpireDelivery(ctx, delivery)
+}
+
+// handleAlertExpired decrements the count of an alert deleted by DynamoDB's time to
+// live because it wasn't sent in a digest.
+func handleAlertExpired(ctx context.Context, r events.DynamoDBEventRecord) error {
+	if !isExpiry(r) {
+		return nil
+	}
 
-		_, err := notification.EnqueueEmail(ctx, []string{subscription.EmailAddress}, FromAddress, notification.EmailTemplate{
-			FileName:    "email/subscription-confirmation.tmpl.html",
-			Subject:     "Subscription Confirmation",
-			ContentType: email.ContentTypeTextHTML,
-			Data: notification.SubscriptionConfirmationTemplateData{
-				WebsiteDomain:  WebsiteDomain,
-				APIDomain:      APIDomain,
-				SubscriptionID: subscription.ID,
-				EmailAddress:   subscription.EmailAddress,
-			},
-		})
+	alert := &db.Alert{}
+	if err := xlambda.UnmarshalDynamoDBEventAttributeValues(r.Change.OldImage, alert); err != nil {
+		return fmt.Errorf("failed to unmarshal DynamoDB record into db.Alert: %w", err)
+	}
+
+	return Store.ExpireAlert(ctx, alert)
+}
+
+// auditSubscription records an audit entry for the creation, status change or removal
+// of a subscription. Modifications that don't change the status, such as backfilling
+// its index keys, aren't recorded.
+func auditSubscription(ctx context.Context, r events.DynamoDBEventRecord) error {
+	image := r.Change.NewImage
+	var action db.AuditAction
+	switch {
+	case r.EventName == EventInsert:
+		action = db.AuditActionCreated
+	case r.EventName == EventModify:
+		action = db.AuditActionStatusChanged
+	case isExpiry(r):
+		action = db.AuditActionExpired
+		image = r.Change.OldImage
+	default:
+		action = db.AuditActionDeleted
+		image = r.Change.OldImage
+	}
+
+	subscription, err := unmarshalSubscription(image)
+	if err != nil {
+		return err
+	}
+	entry := db.NewAuditEntry(r.EventID, action, subscription)
+
+	if action == db.AuditActionStatusChanged {
+		previous, err := unmarshalSubscription(r.Change.OldImage)
 		if err != nil {
-			log.Error(log.Fields{"error": err})
 			return err
 		}
-
-		subscription.IsConfirmed = true
-		if err := subscription.Update(ctx); err != nil {
-			log.Error(log.Fields{"error": err})
-			return err
+		if previous.Status == subscription.Status {
+			return nil
 		}
+		entry.PreviousStatus = previous.Status
 	}
 
-	return nil
+	return Store.CreateAuditEntry(ctx, entry)
+}
+
+// handleOutboxEntryCreated publishes the outbox entries written alongside subscription
+// changes. Entries that still fail once the stream gives up on the record are left
+// pending for the relay lambda to publish.
+func handleOutboxEntryCreated(ctx context.Context, r events.DynamoDBEventRecord) error {
+	entry := &db.OutboxEntry{}
+	if err := xlambda.UnmarshalDynamoDBEventAttributeValues(r.Change.NewImage, entry); err != nil {
+		return fmt.Errorf("failed to unmarshal DynamoDB record into db.OutboxEntry: %w", err)
+	}
+
+	relay := &outbox.Relay{
+		Store:         Store,
+		FromAddress:   FromAddress,
+		APIDomain:     APIDomain,
+		WebsiteDomain: WebsiteDomain,
+	}
+
+	return relay.Publish(ctx, entry)
+}
+
+// handleOutboxEntryExpired decrements the counts of a published outbox entry deleted by
+// DynamoDB's time to live.
+func handleOutboxEntryExpired(ctx context.Context, r events.DynamoDBEventRecord) error {
+	if !isExpiry(r) {
+		return nil
+	}
+
+	entry := &db.OutboxEntry{}
+	if err := xlambda.UnmarshalDynamoDBEventAttributeValues(r.Change.OldImage, entry); err != nil {
+		return fmt.Errorf("failed to unmarshal DynamoDB record into db.OutboxEntry: %w", err)
+	}
+
+	return Store.ExpireOutboxEntry(ctx, entry)
+}
+
+// handleSentEmailExpired decrements the count of a ledger record deleted by DynamoDB's
+// time to live.
+func handleSentEmailExpired(ctx context.Context, r events.DynamoDBEventRecord) error {
+	if !isExpiry(r) {
+		return nil
+	}
+
+	sentEmail := &db.SentEmail{}
+	if err := xlambda.UnmarshalDynamoDBEventAttributeValues(r.Change.OldImage, sentEmail); err != nil {
+		return fmt.Errorf("failed to unmarshal DynamoDB record into db.SentEmail: %w", err)
+	}
+
+	return Store.ExpireSentEmail(ctx, sentEmail)
+}
+
+// isExpiry reports whether the record is DynamoDB's time to live deleting an item.
+func isExpiry(r events.DynamoDBEventRecord) bool {
+	return r.EventName == EventRemove &&
+		r.UserIdentity != nil &&
+		r.UserIdentity.Type == "Service" &&
+		r.UserIdentity.PrincipalID == "dynamodb.amazonaws.com"
+}
+
+func unmarshalSubscription(image map[string]events.DynamoDBAttributeValue) (*db.Subscription, error) {
+	subscription := &db.Subscription{}
+	if err := xlambda.UnmarshalDynamoDBEventAttributeValues(image, subscription); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal DynamoDB record into db.Subscription: %w", err)
+	}
+
+	return subscription, nil
 }
@@ -0,0 +1,263 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gofor-little/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/strongishllama/millhouse.dev-cdk/internal/db"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/notification"
+	"github.com/strongishllama/millhouse.dev-cdk/internal/token"
+	"github.com/strongishllama/millhouse.dev-cdk/lambdas/stream/handler"
+)
+
+func TestDispatcher(t *testing.T) {
+	dispatcher := handler.NewDispatcher()
+
+	calls := []string{}
+	dispatcher.Register(db.ItemTypeSubscription, handler.EventInsert, func(ctx context.Context, r events.DynamoDBEventRecord) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	dispatcher.Register(db.ItemTypeSubscription, handler.EventInsert, func(ctx context.Context, r events.DynamoDBEventRecord) error {
+		calls = append(calls, "second")
+		return errors.New("failed")
+	})
+	dispatcher.Register(db.ItemTypeCount, handler.EventModify, func(ctx context.Context, r events.DynamoDBEventRecord) error {
+		calls = append(calls, "count")
+		return nil
+	})
+
+	require.Error(t, dispatcher.Dispatch(context.Background(), record(handler.EventInsert, "SUBSCRIPTION#test@example.com", map[string]events.DynamoDBAttributeValue{
+		"itemType": events.NewStringAttribute(string(db.ItemTypeSubscription)),
+	}, nil)))
+	require.Equal(t, []string{"first", "second"}, calls)
+
+	// Count items don't have an itemType attribute so the primary key's prefix is used.
+	require.NoError(t, dispatcher.Dispatch(context.Background(), record(handler.EventModify, "COUNT", map[string]events.DynamoDBAttributeValue{}, nil)))
+	require.Equal(t, []string{"first", "second", "count"}, calls)
+
+	// Records without a handler are skipped.
+	require.NoError(t, dispatcher.Dispatch(context.Background(), record(handler.EventRemove, "COUNT", nil, nil)))
+	require.Len(t, calls, 3)
+}
+
+func TestHandlerRemove(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "mbox")
+	require.NoError(t, notification.Initialize(ctx, notification.Config{Backend: notification.BackendFile, FilePath: path}))
+	require.NoError(t, token.Initialize("test-secret"))
+	store := db.NewMemoryStore()
+	handler.Store = store
+
+	pending := db.NewSubscription("pending-id", "pending@example.com")
+	require.NoError(t, store.CreateSubscription(ctx, pending))
+
+	// A pending subscription expired by DynamoDB has its counts decremented.
+	expiry := record(handler.EventRemove, "SUBSCRIPTION#pending@example.com", nil, subscriptionImage(pending))
+	expiry.UserIdentity = &events.DynamoDBUserIdentity{Type: "Service", PrincipalID: "dynamodb.amazonaws.com"}
+	response, err := handler.Handler(ctx, &events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{expiry}})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+
+	count, err := store.GetSubscriptionCount(ctx, db.SubscriptionStatusPending)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), count)
+
+	// A confirmed reader unsubscribing raises an alert.
+	confirmed := db.NewSubscription("confirmed-id", "confirmed@example.com")
+	confirmed.Confirm()
+	removal := record(handler.EventRemove, "SUBSCRIPTION#confirmed@example.com", nil, subscriptionImage(confirmed))
+	response, err = handler.Handler(ctx, &events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{removal}})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+
+	alerts, err := store.GetAlerts(ctx)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	require.Equal(t, db.AlertKindReaderUnsubscribed, alerts[0].Kind)
+}
+
+func TestHandlerAudit(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	ctx := context.Background()
+
+	store := db.NewMemoryStore()
+	handler.Store = store
+
+	pending := db.NewSubscription("subscription-id", "reader@example.com")
+	confirmed := *pending
+	confirmed.Confirm()
+
+	records := []events.DynamoDBEventRecord{
+		record(handler.EventInsert, "SUBSCRIPTION#reader@example.com", subscriptionImage(pending), nil),
+		record(handler.EventModify, "SUBSCRIPTION#reader@example.com", subscriptionImage(&confirmed), subscriptionImage(pending)),
+		// Modifications that don't change the status aren't recorded.
+		record(handler.EventModify, "SUBSCRIPTION#reader@example.com", subscriptionImage(&confirmed), subscriptionImage(&confirmed)),
+		record(handler.EventRemove, "SUBSCRIPTION#reader@example.com", nil, subscriptionImage(&confirmed)),
+	}
+	for i := range records {
+		records[i].EventID = fmt.Sprintf("event-%d", i)
+	}
+	// A retried record doesn't record a second entry.
+	records = append(records, records[0])
+
+	response, err := handler.Handler(ctx, &events.DynamoDBEvent{Records: records})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+
+	entries, err := store.GetAuditEntries(ctx)
+	require.NoError(t, err)
+	actions := []db.AuditAction{}
+	for _, entry := range entries {
+		require.Equal(t, "subscription-id", entry.SubscriptionID)
+		actions = append(actions, entry.Action)
+	}
+	require.ElementsMatch(t, []db.AuditAction{db.AuditActionCreated, db.AuditActionStatusChanged, db.AuditActionDeleted}, actions)
+}
+
+func TestHandlerOutbox(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+	ctx := context.Background()
+
+	path := filepath.Join(t.TempDir(), "mbox")
+	require.NoError(t, notification.Initialize(ctx, notification.Config{Backend: notification.BackendFile, FilePath: path}))
+	require.NoError(t, token.Initialize("test-secret"))
+	store := db.NewMemoryStore()
+	handler.Store = store
+	notification.EmailLedger = store
+	t.Cleanup(func() { notification.EmailLedger = nil })
+
+	subscription := db.NewSubscription("subscription-id", "reader@example.com")
+	subscription.Confirm()
+	entry := db.NewOutboxEntry("entry-id", db.OutboxKindGoodbye, subscription)
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+	require.NoError(t, store.DeleteSubscription(ctx, subscription.EmailAddress, subscription.ID, entry))
+
+	insert := record(handler.EventInsert, "OUTBOX#entry-id", outboxImage(entry), nil)
+	response, err := handler.Handler(ctx, &events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{insert}})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "To: reader@example.com")
+	require.Contains(t, string(data), "Subject: Unsubscribed")
+
+	// A second entry for the same email to the subscription isn't sent again.
+	duplicate := db.NewOutboxEntry("duplicate-id", db.OutboxKindGoodbye, subscription)
+	require.NoError(t, store.CreateSubscription(ctx, subscription))
+	require.NoError(t, store.DeleteSubscription(ctx, subscription.EmailAddress, subscription.ID, duplicate))
+	response, err = handler.Handler(ctx, &events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{
+		record(handler.EventInsert, "OUTBOX#duplicate-id", outboxImage(duplicate), nil),
+	}})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 1, strings.Count(string(data), "Subject: Unsubscribed"))
+
+	entries, err := store.GetPendingOutboxEntries(ctx)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	// Published entries and ledger records expired by DynamoDB have their counts
+	// decremented.
+	entry.Status = db.OutboxStatusPublished
+	duplicate.Status = db.OutboxStatusPublished
+	expiries := []events.DynamoDBEventRecord{
+		record(handler.EventRemove, "OUTBOX#entry-id", nil, outboxImage(entry)),
+		record(handler.EventRemove, "OUTBOX#duplicate-id", nil, outboxImage(duplicate)),
+		record(handler.EventRemove, "SENT_EMAIL#subscription-id#GOODBYE", nil, map[string]events.DynamoDBAttributeValue{
+			"itemType": events.NewStringAttribute(string(db.ItemTypeSentEmail)),
+			"key":      events.NewStringAttribute("subscription-id#GOODBYE"),
+		}),
+	}
+	for i := range expiries {
+		expiries[i].UserIdentity = &events.DynamoDBUserIdentity{Type: "Service", PrincipalID: "dynamodb.amazonaws.com"}
+	}
+	response, err = handler.Handler(ctx, &events.DynamoDBEvent{Records: expiries})
+	require.NoError(t, err)
+	require.Empty(t, response.BatchItemFailures)
+
+	for _, it := range []db.ItemType{db.ItemTypeOutbox, db.ItemTypeSentEmail} {
+		count, err := store.GetCount(ctx, it)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), count)
+	}
+}
+
+func TestHandlerBatchItemFailures(t *testing.T) {
+	log.Log = log.NewStandardLogger(os.Stdout, nil)
+
+	handled := []string{}
+	defaultDispatcher := handler.DefaultDispatcher
+	t.Cleanup(func() { handler.DefaultDispatcher = defaultDispatcher })
+	handler.DefaultDispatcher = handler.NewDispatcher()
+	handler.DefaultDispatcher.Register(db.ItemTypeSubscription, handler.EventInsert, func(ctx context.Context, r events.DynamoDBEventRecord) error {
+		handled = append(handled, r.Change.SequenceNumber)
+		if r.Change.SequenceNumber == "2" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	event := &events.DynamoDBEvent{}
+	for _, sequenceNumber := range []string{"1", "2", "3"} {
+		r := record(handler.EventInsert, "SUBSCRIPTION#test@example.com", nil, nil)
+		r.Change.SequenceNumber = sequenceNumber
+		event.Records = append(event.Records, r)
+	}
+
+	response, err := handler.Handler(context.Background(), event)
+	require.NoError(t, err)
+	require.Equal(t, []events.DynamoDBBatchItemFailure{{ItemIdentifier: "2"}}, response.BatchItemFailures)
+	// The records after the failure are retried with it so they aren't handled yet.
+	require.Equal(t, []string{"1", "2"}, handled)
+}
+
+func record(eventName string, pk string, newImage map[string]events.DynamoDBAttributeValue, oldImage map[string]events.DynamoDBAttributeValue) events.DynamoDBEventRecord {
+	return events.DynamoDBEventRecord{
+		EventID:   "event-id",
+		EventName: eventName,
+		Change: events.DynamoDBStreamRecord{
+			Keys: map[string]events.DynamoDBAttributeValue{
+				"pk": events.NewStringAttribute(pk),
+			},
+			NewImage: newImage,
+			OldImage: oldImage,
+		},
+	}
+}
+
+func subscriptionImage(subscription *db.Subscription) map[string]events.DynamoDBAttributeValue {
+	return map[string]events.DynamoDBAttributeValue{
+		"itemType":     events.NewStringAttribute(string(db.ItemTypeSubscription)),
+		"emailAddress": events.NewStringAttribute(subscription.EmailAddress),
+		"id":           events.NewStringAttribute(subscription.ID),
+		"status":       events.NewStringAttribute(string(subscription.Status)),
+	}
+}
+
+func outboxImage(entry *db.OutboxEntry) map[string]events.DynamoDBAttributeValue {
+	return map[string]events.DynamoDBAttributeValue{
+		"itemType":       events.NewStringAttribute(string(db.ItemTypeOutbox)),
+		"id":             events.NewStringAttribute(entry.ID),
+		"kind":           events.NewStringAttribute(string(entry.Kind)),
+		"status":         events.NewStringAttribute(string(entry.Status)),
+		"subscriptionId": events.NewStringAttribute(entry.SubscriptionID),
+		"emailAddress":   events.NewStringAttribute(entry.EmailAddress),
+		"createdAt":      events.NewStringAttribute(entry.CreatedAt.Format(time.RFC3339Nano)),
+	}
+}
@@ -14,7 +14,31 @@ import { Method } from './method';
 
 export interface ApiStackProps extends cdk.StackProps {
   readonly accessControlAllowOrigin: string;
-  readonly recaptchaSecretArn: string;
+  readonly captchaSecretArn: string;
+  /**
+   * The captcha provider the website uses. One of 'recaptcha-v2', 'recaptcha-v3',
+   * 'hcaptcha' or 'turnstile'. Defaults to 'recaptcha-v3'.
+   */
+  readonly captchaProvider?: string;
+  /**
+   * The hostnames captcha challenges may be completed on. Any hostname is accepted if
+   * it's undefined.
+   */
+  readonly captchaHostnames?: string[];
+  /**
+   * The action name the website's captcha challenges are completed for. Only checked
+   * for the providers that return it. Any action is accepted if it's undefined.
+   */
+  readonly captchaAction?: string;
+  /**
+   * The captcha score that must be exceeded to be accepted. Defaults to 0.5.
+   */
+  readonly captchaAcceptScore?: number;
+  /**
+   * The lowest captcha score that is held for the owner to review. Lower scores are
+   * rejected. Defaults to 0.3.
+   */
+  readonly captchaHoldScore?: number;
   readonly baseDomainName: string;
   readonly fullDomainName: string;
 }
@@ -25,6 +49,7 @@ export class ApiStack extends cdk.Stack {
 
     const table = dynamodb.Table.fromTableArn(this, 'subscription-table', ssm.StringParameter.fromStringParameterName(this, 'table-arn', 'table-arn').stringValue);
     const emailQueue = sqs.Queue.fromQueueArn(this, 'email-queue', ssm.StringParameter.fromStringParameterName(this, 'email-queue-arn', 'email-queue-arn').stringValue);
+    const tokenSecretArn = ssm.StringParameter.fromStringParameterName(this, 'token-secret-arn', 'token-secret-arn').stringValue;
 
     const api = new apigateway.RestApi(this, 'rest-api', {
       defaultCorsPreflightOptions: {
@@ -42,9 +67,16 @@ export class ApiStack extends cdk.Stack {
     api.root.addResource('subscribe').addMethod(Method.PUT, new apigateway.LambdaIntegration(new go_lambda.GoFunction(this, 'subscribe-function', {
       entry: 'lambdas/api/subscribe',
       bundling: bundling,
+      // Leaves time to retry the captcha verification if the provider fails.
+      timeout: cdk.Duration.seconds(10),
       environment: {
         'ACCESS_CONTROL_ALLOW_ORIGIN': props.accessControlAllowOrigin,
-        'RECAPTCHA_SECRET_ARN': props.recaptchaSecretArn,
+        'CAPTCHA_PROVIDER': props.captchaProvider ?? 'recaptcha-v3',
+        'CAPTCHA_SECRET_ARN': props.captchaSecretArn,
+        'CAPTCHA_HOSTNAMES': (props.captchaHostnames ?? []).join(','),
+        'CAPTCHA_ACTION': props.captchaAction ?? '',
+        'CAPTCHA_ACCEPT_SCORE': (props.captchaAcceptScore ?? 0.5).toString(),
+        'CAPTCHA_HOLD_SCORE': (props.captchaHoldScore ?? 0.3).toString(),
         'EMAIL_QUEUE_URL': emailQueue.queueUrl,
         'TABLE_NAME': table.tableName
       },
@@ -54,11 +86,12 @@ export class ApiStack extends cdk.Stack {
             SecretsManager.GET_SECRET_VALUE
           ],
           resources: [
-            props.recaptchaSecretArn
+            props.captchaSecretArn
           ]
         }),
         new iam.PolicyStatement({
           actions: [
+            DynamoDB.DELETE_ITEM,
             DynamoDB.GET_ITEM,
             DynamoDB.PUT_ITEM,
             DynamoDB.QUERY,
@@ -80,18 +113,63 @@ export class ApiStack extends cdk.Stack {
       ]
     })));
 
-    // Add unsubscribe method - /unsubscribe
-    api.root.addResource('unsubscribe').addMethod(Method.GET, new apigateway.LambdaIntegration(new go_lambda.GoFunction(this, 'unsubscribe-function', {
+    // Add confirm method - /confirm
+    api.root.addResource('confirm').addMethod(Method.GET, new apigateway.LambdaIntegration(new go_lambda.GoFunction(this, 'confirm-function', {
+      entry: 'lambdas/api/confirm',
+      bundling: bundling,
+      environment: {
+        'ACCESS_CONTROL_ALLOW_ORIGIN': props.accessControlAllowOrigin,
+        'TABLE_NAME': table.tableName,
+        'TOKEN_SECRET_ARN': tokenSecretArn
+      },
+      initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            SecretsManager.GET_SECRET_VALUE
+          ],
+          resources: [
+            tokenSecretArn
+          ]
+        }),
+        new iam.PolicyStatement({
+          actions: [
+            DynamoDB.GET_ITEM,
+            DynamoDB.PUT_ITEM,
+            DynamoDB.QUERY,
+            DynamoDB.UPDATE_ITEM
+          ],
+          resources: [
+            table.tableArn
+          ]
+        })
+      ]
+    })));
+
+    // Add unsubscribe methods - /unsubscribe
+    // GET is the link in emails and POST is the RFC 8058 one-click unsubscribe.
+    const unsubscribeIntegration = new apigateway.LambdaIntegration(new go_lambda.GoFunction(this, 'unsubscribe-function', {
       entry: 'lambdas/api/unsubscribe',
       bundling: bundling,
       environment: {
         'ACCESS_CONTROL_ALLOW_ORIGIN': props.accessControlAllowOrigin,
-        'TABLE_NAME': table.tableName
+        'TABLE_NAME': table.tableName,
+        'TOKEN_SECRET_ARN': tokenSecretArn
       },
       initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            SecretsManager.GET_SECRET_VALUE
+          ],
+          resources: [
+            tokenSecretArn
+          ]
+        }),
         new iam.PolicyStatement({
           actions: [
             DynamoDB.DELETE_ITEM,
+            DynamoDB.GET_ITEM,
+            DynamoDB.PUT_ITEM,
+            DynamoDB.QUERY,
             DynamoDB.UPDATE_ITEM
           ],
           resources: [
@@ -99,6 +177,29 @@ export class ApiStack extends cdk.Stack {
           ]
         })
       ]
+    }));
+    const unsubscribeResource = api.root.addResource('unsubscribe');
+    unsubscribeResource.addMethod(Method.GET, unsubscribeIntegration);
+    unsubscribeResource.addMethod(Method.POST, unsubscribeIntegration);
+
+    // Add stats method - /stats
+    api.root.addResource('stats').addMethod(Method.GET, new apigateway.LambdaIntegration(new go_lambda.GoFunction(this, 'stats-function', {
+      entry: 'lambdas/api/stats',
+      bundling: bundling,
+      environment: {
+        'ACCESS_CONTROL_ALLOW_ORIGIN': props.accessControlAllowOrigin,
+        'TABLE_NAME': table.tableName
+      },
+      initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            DynamoDB.GET_ITEM
+          ],
+          resources: [
+            table.tableArn
+          ]
+        })
+      ]
     })));
 
     const hostedZone = route53.HostedZone.fromLookup(this, 'hosted-zone', {
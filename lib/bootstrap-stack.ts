@@ -1,14 +1,16 @@
 import * as cdk from '@aws-cdk/core';
 import * as backup from '@aws-cdk/aws-backup';
 import * as dynamodb from '@aws-cdk/aws-dynamodb';
+import * as events from '@aws-cdk/aws-events';
+import * as events_targets from '@aws-cdk/aws-events-targets';
 import * as iam from '@aws-cdk/aws-iam';
 import * as lambda from '@aws-cdk/aws-lambda';
 import * as go_lambda from '@aws-cdk/aws-lambda-go';
 import * as lambda_events from '@aws-cdk/aws-lambda-event-sources';
+import * as secretsmanager from '@aws-cdk/aws-secretsmanager';
 import * as ssm from '@aws-cdk/aws-ssm';
 import * as sqs from '@aws-cdk/aws-sqs';
-import { EmailService } from '@strongishllama/email-service-cdk';
-import { DynamoDB, SQS } from '@strongishllama/aws-iam-constants';
+import { DynamoDB, SecretsManager, SQS } from '@strongishllama/aws-iam-constants';
 import { bundling } from './lambda';
 
 export interface BootstrapStackProps extends cdk.StackProps {
@@ -17,6 +19,11 @@ export interface BootstrapStackProps extends cdk.StackProps {
   readonly fromAddress: string;
   readonly apiDomainName: string;
   readonly websiteDomainName: string;
+  /**
+   * The address the daily digest of owner alerts, such as readers unsubscribing, is
+   * sent to. The digest isn't sent if it's undefined.
+   */
+  readonly ownerAddress?: string;
 }
 
 export class BootstrapStack extends cdk.Stack {
@@ -27,9 +34,40 @@ export class BootstrapStack extends cdk.Stack {
       throw Error('BootstrapStackProps.env property must be fully defined.');
     }
 
-    const emailService = new EmailService(this, 'email-service', {
-      receiveMessageWaitTime: cdk.Duration.seconds(20)
+    // Emails are enqueued as notification.Message JSON and sent via SES by the mailer so
+    // their plain-text part and headers, such as List-Unsubscribe, are delivered.
+    const emailQueue = new sqs.Queue(this, 'email-queue', {
+      receiveMessageWaitTime: cdk.Duration.seconds(20),
+      visibilityTimeout: cdk.Duration.minutes(3),
+      deadLetterQueue: {
+        maxReceiveCount: 3,
+        queue: new sqs.Queue(this, 'email-dead-letter-queue', {
+          receiveMessageWaitTime: cdk.Duration.seconds(20)
+        })
+      }
     });
+    const mailerFunction = new go_lambda.GoFunction(this, 'mailer-function', {
+      entry: 'lambdas/mailer',
+      bundling: bundling,
+      timeout: cdk.Duration.seconds(30),
+      environment: {
+        'EMAIL_BACKEND': 'ses'
+      },
+      initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            'ses:SendRawEmail'
+          ],
+          resources: [
+            '*'
+          ]
+        })
+      ]
+    });
+    mailerFunction.addEventSource(new lambda_events.SqsEventSource(emailQueue, {
+      batchSize: 10,
+      reportBatchItemFailures: true
+    }));
 
     const table = new dynamodb.Table(this, 'table', {
       partitionKey: {
@@ -41,32 +79,63 @@ export class BootstrapStack extends cdk.Stack {
         type: dynamodb.AttributeType.STRING
       },
       billingMode: dynamodb.BillingMode.PAY_PER_REQUEST,
-      stream: dynamodb.StreamViewType.NEW_IMAGE,
+      stream: dynamodb.StreamViewType.NEW_AND_OLD_IMAGES,
+      timeToLiveAttribute: 'expiresAt',
       removalPolicy: props.tableRemovalPolicy
     });
+    table.addGlobalSecondaryIndex({
+      indexName: 'Gsi1',
+      partitionKey: {
+        name: 'gsiPk1',
+        type: dynamodb.AttributeType.STRING
+      },
+      sortKey: {
+        name: 'gsiSk1',
+        type: dynamodb.AttributeType.STRING
+      }
+    });
+
+    // Secret used to encrypt the confirm and unsubscribe tokens included in emails.
+    const tokenSecret = new secretsmanager.Secret(this, 'token-secret', {
+      generateSecretString: {
+        excludePunctuation: true,
+        passwordLength: 64
+      }
+    });
 
     const streamFunction = new go_lambda.GoFunction(this, 'stream-function', {
       entry: 'lambdas/stream',
       bundling: bundling,
       environment: {
         'FROM_ADDRESS': props.fromAddress,
-        'EMAIL_QUEUE_URL': emailService.queue.queueUrl,
+        'EMAIL_QUEUE_URL': emailQueue.queueUrl,
         'TABLE_NAME': table.tableName,
         'API_DOMAIN': props.apiDomainName,
-        'WEBSITE_DOMAIN': props.websiteDomainName
+        'WEBSITE_DOMAIN': props.websiteDomainName,
+        'TOKEN_SECRET_ARN': tokenSecret.secretArn
       },
       initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            SecretsManager.GET_SECRET_VALUE
+          ],
+          resources: [
+            tokenSecret.secretArn
+          ]
+        }),
         new iam.PolicyStatement({
           actions: [
             DynamoDB.UPDATE_ITEM,
             SQS.SEND_MESSAGE
           ],
           resources: [
-            emailService.queue.queueArn
+            emailQueue.queueArn
           ]
         }),
         new iam.PolicyStatement({
           actions: [
+            DynamoDB.DELETE_ITEM,
+            DynamoDB.PUT_ITEM,
             DynamoDB.UPDATE_ITEM
           ],
           resources: [
@@ -81,10 +150,196 @@ export class BootstrapStack extends cdk.Stack {
       onFailure: new lambda_events.SqsDlq(new sqs.Queue(this, 'stream-dead-letter-queue', {
         receiveMessageWaitTime: cdk.Duration.seconds(20)
       })),
-      retryAttempts: 0,
+      // Failed records are retried on their own as the function reports which record
+      // of the batch failed.
+      reportBatchItemFailures: true,
+      retryAttempts: 3,
       startingPosition: lambda.StartingPosition.TRIM_HORIZON
     }));
 
+    // Publish the outbox entries the stream function gave up on.
+    const relayFunction = new go_lambda.GoFunction(this, 'relay-function', {
+      entry: 'lambdas/relay',
+      bundling: bundling,
+      timeout: cdk.Duration.minutes(5),
+      environment: {
+        'FROM_ADDRESS': props.fromAddress,
+        'EMAIL_QUEUE_URL': emailQueue.queueUrl,
+        'TABLE_NAME': table.tableName,
+        'API_DOMAIN': props.apiDomainName,
+        'WEBSITE_DOMAIN': props.websiteDomainName,
+        'TOKEN_SECRET_ARN': tokenSecret.secretArn
+      },
+      initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            SecretsManager.GET_SECRET_VALUE
+          ],
+          resources: [
+            tokenSecret.secretArn
+          ]
+        }),
+        new iam.PolicyStatement({
+          actions: [
+            SQS.SEND_MESSAGE
+          ],
+          resources: [
+            emailQueue.queueArn
+          ]
+        }),
+        new iam.PolicyStatement({
+          actions: [
+            DynamoDB.DELETE_ITEM,
+            DynamoDB.PUT_ITEM,
+            DynamoDB.QUERY,
+            DynamoDB.UPDATE_ITEM
+          ],
+          resources: [
+            table.tableArn,
+            `${table.tableArn}/index/*`
+          ]
+        })
+      ]
+    });
+    new events.Rule(this, 'relay-schedule', {
+      schedule: events.Schedule.rate(cdk.Duration.minutes(15)),
+      targets: [
+        new events_targets.LambdaFunction(relayFunction)
+      ]
+    });
+
+    // Recompute the subscription count daily to correct any drift, such as from pending
+    // subscriptions expired by DynamoDB's time to live.
+    const reconcileFunction = new go_lambda.GoFunction(this, 'reconcile-function', {
+      entry: 'lambdas/reconcile',
+      bundling: bundling,
+      timeout: cdk.Duration.minutes(5),
+      environment: {
+        'TABLE_NAME': table.tableName
+      },
+      initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            DynamoDB.SCAN,
+            DynamoDB.UPDATE_ITEM
+          ],
+          resources: [
+            table.tableArn
+          ]
+        })
+      ]
+    });
+    new events.Rule(this, 'reconcile-schedule', {
+      schedule: events.Schedule.rate(cdk.Duration.days(1)),
+      targets: [
+        new events_targets.LambdaFunction(reconcileFunction)
+      ]
+    });
+
+    // Email new posts to every confirmed subscription. It can be invoked directly with the
+    // post or by publishing a 'Post Published' event with the post as its detail.
+    const broadcastFunction = new go_lambda.GoFunction(this, 'broadcast-function', {
+      entry: 'lambdas/broadcast',
+      bundling: bundling,
+      timeout: cdk.Duration.minutes(15),
+      environment: {
+        'FROM_ADDRESS': props.fromAddress,
+        'EMAIL_QUEUE_URL': emailQueue.queueUrl,
+        'TABLE_NAME': table.tableName,
+        'API_DOMAIN': props.apiDomainName,
+        'WEBSITE_DOMAIN': props.websiteDomainName,
+        'TOKEN_SECRET_ARN': tokenSecret.secretArn
+      },
+      initialPolicy: [
+        new iam.PolicyStatement({
+          actions: [
+            SecretsManager.GET_SECRET_VALUE
+          ],
+          resources: [
+            tokenSecret.secretArn
+          ]
+        }),
+        new iam.PolicyStatement({
+          actions: [
+            SQS.SEND_MESSAGE
+          ],
+          resources: [
+            emailQueue.queueArn
+          ]
+        }),
+        new iam.PolicyStatement({
+          actions: [
+            DynamoDB.DELETE_ITEM,
+            DynamoDB.GET_ITEM,
+            DynamoDB.PUT_ITEM,
+            DynamoDB.QUERY,
+            DynamoDB.UPDATE_ITEM
+          ],
+          resources: [
+            table.tableArn,
+            `${table.tableArn}/index/*`
+          ]
+        })
+      ]
+    });
+    new events.Rule(this, 'broadcast-rule', {
+      eventPattern: {
+        source: ['millhouse.dev'],
+        detailType: ['Post Published']
+      },
+      targets: [
+        new events_targets.LambdaFunction(broadcastFunction, {
+          event: events.RuleTargetInput.fromEventPath('$.detail'),
+          retryAttempts: 2
+        })
+      ]
+    });
+
+    // Email the owner a daily digest of the alerts raised by the subscribe and stream
+    // functions.
+    if (props.ownerAddress !== undefined) {
+      const digestFunction = new go_lambda.GoFunction(this, 'digest-function', {
+        entry: 'lambdas/digest',
+        bundling: bundling,
+        timeout: cdk.Duration.minutes(1),
+        environment: {
+          'FROM_ADDRESS': props.fromAddress,
+          'OWNER_ADDRESS': props.ownerAddress,
+          'EMAIL_QUEUE_URL': emailQueue.queueUrl,
+          'TABLE_NAME': table.tableName,
+          'WEBSITE_DOMAIN': props.websiteDomainName
+        },
+        initialPolicy: [
+          new iam.PolicyStatement({
+            actions: [
+              SQS.SEND_MESSAGE
+            ],
+            resources: [
+              emailQueue.queueArn
+            ]
+          }),
+          new iam.PolicyStatement({
+            actions: [
+              DynamoDB.DELETE_ITEM,
+              DynamoDB.PUT_ITEM,
+              DynamoDB.QUERY,
+              DynamoDB.UPDATE_ITEM
+            ],
+            resources: [
+              table.tableArn,
+              `${table.tableArn}/index/*`
+            ]
+          })
+        ]
+      });
+      new events.Rule(this, 'digest-schedule', {
+        schedule: events.Schedule.rate(cdk.Duration.days(1)),
+        targets: [
+          new events_targets.LambdaFunction(digestFunction)
+        ]
+      });
+    }
+
     if (props.enableBackups) {
       const backupPlan = backup.BackupPlan.dailyMonthly1YearRetention(this, 'backup-plan');
       backupPlan.addSelection('selection', {
@@ -102,7 +357,12 @@ export class BootstrapStack extends cdk.Stack {
     new ssm.StringParameter(this, 'queue-arn', {
       parameterName: 'email-queue-arn',
       tier: ssm.ParameterTier.STANDARD,
-      stringValue: emailService.queue.queueArn
+      stringValue: emailQueue.queueArn
+    });
+    new ssm.StringParameter(this, 'token-secret-arn', {
+      parameterName: 'token-secret-arn',
+      tier: ssm.ParameterTier.STANDARD,
+      stringValue: tokenSecret.secretArn
     });
   }
 }
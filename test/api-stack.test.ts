@@ -0,0 +1,57 @@
+import { expect as expectCDK, haveResourceLike, SynthUtils } from '@aws-cdk/assert';
+import * as cdk from '@aws-cdk/core';
+import { DynamoDB } from '@strongishllama/aws-iam-constants';
+import { ApiStack } from '../lib/api-stack';
+
+function newStack(): ApiStack {
+  return new ApiStack(new cdk.App(), 'test-api-stack', {
+    env: {
+      account: '123456789012',
+      region: 'ap-southeast-2'
+    },
+    accessControlAllowOrigin: 'https://example.com',
+    captchaSecretArn: 'arn:aws:secretsmanager:ap-southeast-2:123456789012:secret:captcha-secret',
+    baseDomainName: 'example.com',
+    fullDomainName: 'api.example.com'
+  });
+}
+
+// functionActions returns every action the policy of the function with the given
+// construct ID allows.
+function functionActions(stack: cdk.Stack, functionId: string): string[] {
+  const resources = SynthUtils.toCloudFormation(stack).Resources;
+  const prefix = `${functionId.replace(/-/g, '')}ServiceRoleDefaultPolicy`;
+  const logicalId = Object.keys(resources).find(id => id.startsWith(prefix) && resources[id].Type === 'AWS::IAM::Policy');
+  if (logicalId === undefined) {
+    throw new Error(`no policy found for ${functionId}`);
+  }
+
+  const actions: string[] = [];
+  for (const statement of resources[logicalId].Properties.PolicyDocument.Statement) {
+    actions.push(...([] as string[]).concat(statement.Action));
+  }
+
+  return actions;
+}
+
+test('unsubscribe function can read and delete subscriptions', () => {
+  // The subscription is read by its email address, which is a query of its partition.
+  expect(functionActions(newStack(), 'unsubscribe-function')).toEqual(expect.arrayContaining([
+    DynamoDB.DELETE_ITEM,
+    DynamoDB.GET_ITEM,
+    DynamoDB.PUT_ITEM,
+    DynamoDB.QUERY,
+    DynamoDB.UPDATE_ITEM
+  ]));
+});
+
+test('subscribe function defaults to the recommended reCAPTCHA v3 threshold', () => {
+  expectCDK(newStack()).to(haveResourceLike('AWS::Lambda::Function', {
+    Environment: {
+      Variables: {
+        CAPTCHA_ACCEPT_SCORE: '0.5',
+        CAPTCHA_HOLD_SCORE: '0.3'
+      }
+    }
+  }));
+});